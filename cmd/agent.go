@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lucasepe/kvs/internal/agent"
+	"github.com/lucasepe/kvs/internal/store"
+	"github.com/lucasepe/toolbox/flags/commander"
+)
+
+const (
+	envAgentSock       = "KVS_AGENT_SOCK"
+	defaultIdleTimeout = 15 * time.Minute
+)
+
+func newCmdAgent() *cmdAgent {
+	return &cmdAgent{}
+}
+
+type cmdAgent struct {
+	socket     string
+	timeout    time.Duration
+	foreground bool
+}
+
+func (*cmdAgent) Name() string { return "agent" }
+func (*cmdAgent) Synopsis() string {
+	return "Start the agent that keeps the unlocked key in memory."
+}
+func (*cmdAgent) Usage() string {
+	return strings.ReplaceAll(`{NAME} agent [-a socket] [-t timeout] [-f]
+
+   Start the agent in the background and set KVS_AGENT_SOCK:
+     eval $({NAME} agent)
+
+   Wipe the key after 5 minutes of inactivity:
+     eval $({NAME} agent -t 5m)
+
+   Then use '{NAME} unlock' to hand the secret phrase to the agent
+   and '{NAME} lock' to make it forget the key.`, "{NAME}", appName)
+}
+
+func (p *cmdAgent) SetFlags(fs *flag.FlagSet) {
+	def := agent.DefaultSocket()
+	fs.StringVar(&p.socket, "a", def, fmt.Sprintf("socket path (default: %s)", def))
+	fs.DurationVar(&p.timeout, "t", defaultIdleTimeout, "wipe the key after this idle time, 0 to keep it")
+	fs.BoolVar(&p.foreground, "f", false, "run in the foreground")
+}
+
+func (p *cmdAgent) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	var err error
+	if p.foreground {
+		err = p.serve()
+	} else {
+		err = p.start()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	return commander.ExitSuccess
+}
+
+// start runs the agent in a detached process and prints
+// the shell commands that export the socket path.
+func (p *cmdAgent) start() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	c := exec.Command(exe, "agent", "-f", "-a", p.socket, "-t", p.timeout.String())
+	detach(c)
+	if err := c.Start(); err != nil {
+		return err
+	}
+
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("unix", p.socket); err == nil {
+			conn.Close()
+			fmt.Printf("%s=%s; export %s;\n", envAgentSock, p.socket, envAgentSock)
+			fmt.Printf("echo Agent pid %d;\n", c.Process.Pid)
+			return c.Process.Release()
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	c.Process.Kill()
+	return fmt.Errorf("the agent did not start, try '%s agent -f' to see why", appName)
+}
+
+func (p *cmdAgent) serve() error {
+	l, err := agent.Listen(p.socket)
+	if err != nil {
+		return err
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		l.Close()
+	}()
+
+	return agent.New(p.timeout).Serve(l)
+}
+
+func newCmdLock() *cmdLock {
+	return &cmdLock{}
+}
+
+type cmdLock struct{}
+
+func (*cmdLock) Name() string { return "lock" }
+func (*cmdLock) Synopsis() string {
+	return "Make the agent forget the key."
+}
+func (*cmdLock) Usage() string {
+	return strings.ReplaceAll(`{NAME} lock`, "{NAME}", appName)
+}
+
+func (*cmdLock) SetFlags(fs *flag.FlagSet) {}
+
+func (*cmdLock) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	c, err := agentClient()
+	if err == nil {
+		err = c.Lock()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	return commander.ExitSuccess
+}
+
+func newCmdUnlock() *cmdUnlock {
+	return &cmdUnlock{}
+}
+
+type cmdUnlock struct {
+	store string
+}
+
+func (*cmdUnlock) Name() string { return "unlock" }
+func (*cmdUnlock) Synopsis() string {
+	return "Hand the key derived from the secret phrase to the agent."
+}
+func (*cmdUnlock) Usage() string {
+	return strings.ReplaceAll(`{NAME} unlock [-s store]
+
+   The key is read from the key file, the KVS_SECRET env var,
+   the key command or typed on the terminal, in this order,
+   and it is checked against the store before being handed over.`, "{NAME}", appName)
+}
+
+func (p *cmdUnlock) SetFlags(fs *flag.FlagSet) {
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdUnlock) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	c, err := agentClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	key, ok, err := secrets.resolve(false)
+	if err == nil && !ok {
+		err = fmt.Errorf("the secret phrase is required")
+	}
+	if err == nil {
+		err = p.verify(key)
+	}
+	if err == nil {
+		err = c.Unlock(key)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	return commander.ExitSuccess
+}
+
+// verify checks that the key opens the store, so that the agent
+// never caches a mistyped secret phrase; a store that does not exist
+// yet or holds nothing encrypted accepts any key.
+func (p *cmdUnlock) verify(key []byte) error {
+	if _, err := os.Stat(p.store); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	db, err := newStore(store.Options{Path: p.store, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = db.Unlock(key)
+	if err == nil {
+		err = db.CheckKey()
+	}
+	if errors.Is(err, store.ErrWrongKey) {
+		return fmt.Errorf("the secret phrase does not open '%s'", p.store)
+	}
+	if errors.Is(err, store.ErrNothingToVerify) {
+		return nil
+	}
+	return err
+}
+
+// agentClient returns the client of the agent set by KVS_AGENT_SOCK.
+func agentClient() (*agent.Client, error) {
+	sock := os.Getenv(envAgentSock)
+	if len(sock) == 0 {
+		return nil, fmt.Errorf("%s is not set, start the agent with: eval $(%s agent)", envAgentSock, appName)
+	}
+	return agent.NewClient(sock), nil
+}
@@ -0,0 +1,400 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lucasepe/kvs/internal/aes"
+	"github.com/lucasepe/kvs/internal/store"
+	"github.com/lucasepe/toolbox/flags/commander"
+)
+
+const (
+	defaultBackupKeep = 7
+	backupTimeFormat  = "20060102-150405.000"
+	backupExt         = ".bak"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func newCmdBackup() *cmdBackup {
+	return &cmdBackup{}
+}
+
+type cmdBackup struct {
+	store    string
+	output   string
+	compress bool
+	encrypt  bool
+	keep     int
+}
+
+func (*cmdBackup) Name() string { return "backup" }
+func (*cmdBackup) Synopsis() string {
+	return "Take a consistent snapshot of a store."
+}
+func (*cmdBackup) Usage() string {
+	return strings.ReplaceAll(`{NAME} backup [-s store] [-o file] [-z] [-e] [-keep n]
+
+   Take a timestamped backup next to the default store,
+   keeping only the 7 most recent ones:
+     {NAME} backup
+
+   Write a compressed and encrypted snapshot to a file:
+     {NAME} backup -z -e -o /mnt/usb/secrets.bak
+
+   The snapshot is consistent even while other commands use the store.`, "{NAME}", appName)
+}
+
+func (p *cmdBackup) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&p.output, "o", "", "snapshot file, '-' for stdout (default: timestamped file in the store directory)")
+	fs.BoolVar(&p.compress, "z", false, "compress the snapshot")
+	fs.BoolVar(&p.encrypt, "e", false, "encrypt the snapshot")
+	fs.IntVar(&p.keep, "keep", defaultBackupKeep, "number of timestamped backups to keep")
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdBackup) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	var key []byte
+	if p.encrypt {
+		k, ok, err := secretKey()
+		if err == nil && !ok {
+			err = fmt.Errorf("the secret phrase is required to encrypt the snapshot")
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return commander.ExitFailure
+		}
+		key = k
+	}
+
+	var db snapshotter
+	c, remote, err := remoteClient()
+	if remote && err == nil && len(p.output) == 0 {
+		err = fmt.Errorf("-o is required with %s", envAddr)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	if remote {
+		db, p.store = c, os.Getenv(envAddr)
+	} else {
+		s, err := newStore(store.Options{Path: p.store, Outdated: true, ReadOnly: true})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return commander.ExitFailure
+		}
+		defer s.Close()
+		db = s
+	}
+
+	if p.output == "-" {
+		err = writeSnapshot(db, os.Stdout, p.compress, key)
+	} else {
+		err = p.backupToFile(db, key)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	return commander.ExitSuccess
+}
+
+// snapshotter is a store, or the server sharing it.
+type snapshotter interface {
+	Backup(w io.Writer) (int64, error)
+}
+
+func (p *cmdBackup) backupToFile(db snapshotter, key []byte) error {
+	rotate := len(p.output) == 0
+	if rotate {
+		p.output = backupFile(p.store, time.Now())
+	}
+
+	if err := saveSnapshot(db, p.output, p.compress, key); err != nil {
+		return err
+	}
+	fmt.Printf("backup of '%s' saved to '%s'\n", p.store, p.output)
+
+	if rotate {
+		return rotateBackups(p.store, p.keep)
+	}
+	return nil
+}
+
+func newCmdRestore() *cmdRestore {
+	return &cmdRestore{}
+}
+
+type cmdRestore struct {
+	store string
+	merge bool
+	force bool
+	keep  int
+}
+
+func (*cmdRestore) Name() string { return "restore" }
+func (*cmdRestore) Synopsis() string {
+	return "Restore a store from a snapshot."
+}
+func (*cmdRestore) Usage() string {
+	return strings.ReplaceAll(`{NAME} restore [-s store] [-merge [-f]] <snapshot>
+
+   Replace the default store with a snapshot:
+     {NAME} restore ~/.config/kvs/secrets-20230102-150405.000.bak
+
+   Add the keys of a snapshot to the 'work' store, replacing the existing ones:
+     {NAME} restore -s work -merge -f work.bak
+
+   The snapshot is checked before being used; the store
+   being replaced is backed up first.`, "{NAME}", appName)
+}
+
+func (p *cmdRestore) SetFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&p.merge, "merge", false, "add the keys of the snapshot to the store instead of replacing it")
+	fs.BoolVar(&p.force, "f", false, "replace the existing keys when merging")
+	fs.IntVar(&p.keep, "keep", defaultBackupKeep, "number of timestamped backups to keep")
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdRestore) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "snapshot file is required")
+		return commander.ExitFailure
+	}
+
+	if err := p.restore(fs.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	return commander.ExitSuccess
+}
+
+func (p *cmdRestore) restore(snapshot string) error {
+	tmp, err := loadSnapshot(snapshot, filepath.Dir(p.store))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	src, err := openStore(store.Options{Path: tmp, Outdated: true})
+	if err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+	defer src.Close()
+
+	// snapshots of older versions are upgraded, being a copy
+	if _, _, err := src.Migrate(); err != nil {
+		return err
+	}
+
+	report, err := src.Check()
+	if err != nil {
+		return err
+	}
+	if len(report.Problems) > 0 {
+		for _, el := range report.Problems {
+			fmt.Fprintln(os.Stderr, el)
+		}
+		return fmt.Errorf("invalid snapshot: %d problems found", len(report.Problems))
+	}
+
+	if p.merge {
+		db, err := openStore(store.Options{Path: p.store})
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		copied, skipped, err := db.Merge(src, p.force)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d keys restored into '%s', %d existing keys skipped\n", copied, p.store, skipped)
+		return nil
+	}
+
+	_, err = os.Stat(p.store)
+	exists := err == nil
+
+	// the store stays locked from the backup to the replace,
+	// written through the same handle
+	db, err := newStore(store.Options{Path: p.store, Outdated: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if exists {
+		prev := backupFile(p.store, time.Now())
+		if err := saveSnapshot(db, prev, false, nil); err != nil {
+			return err
+		}
+		if err := rotateBackups(p.store, p.keep); err != nil {
+			return err
+		}
+		fmt.Printf("previous store saved to '%s'\n", prev)
+	}
+
+	if err := db.Replace(src); err != nil {
+		return err
+	}
+
+	fmt.Printf("'%s' restored from '%s'\n", p.store, snapshot)
+	return nil
+}
+
+// backupFile returns the name of the timestamped backup of the store.
+func backupFile(storePath string, t time.Time) string {
+	base := strings.TrimSuffix(storePath, filepath.Ext(storePath))
+	return fmt.Sprintf("%s-%s%s", base, t.Format(backupTimeFormat), backupExt)
+}
+
+// rotateBackups deletes the oldest timestamped backups of the store, keeping
+// the most recent ones: the names sort as the timestamps they hold.
+func rotateBackups(storePath string, keep int) error {
+	base := strings.TrimSuffix(storePath, filepath.Ext(storePath))
+	all, err := filepath.Glob(base + "-*" + backupExt)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, el := range all {
+		ts := strings.TrimSuffix(strings.TrimPrefix(el, base+"-"), backupExt)
+		if _, err := time.Parse(backupTimeFormat, ts); err == nil {
+			names = append(names, el)
+		}
+	}
+	sort.Strings(names)
+
+	for len(names) > keep && keep > 0 {
+		if err := os.Remove(names[0]); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+
+	return nil
+}
+
+// saveSnapshot writes the snapshot to a temporary file, renamed
+// to path only once complete.
+func saveSnapshot(db snapshotter, path string, compress bool, key []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	err = writeSnapshot(db, f, compress, key)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// writeSnapshot writes the snapshot of the store to w,
+// compressed and then encrypted if key is not nil.
+func writeSnapshot(db snapshotter, w io.Writer, compress bool, key []byte) error {
+	var closers []io.Closer
+	if key != nil {
+		enc, err := aes.NewWriter(w, key)
+		if err != nil {
+			return err
+		}
+		w = enc
+		closers = append(closers, enc)
+	}
+	if compress {
+		zw := gzip.NewWriter(w)
+		w = zw
+		closers = append(closers, zw)
+	}
+
+	if _, err := db.Backup(w); err != nil {
+		return err
+	}
+
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSnapshot decrypts and decompresses, as needed, the snapshot
+// into a temporary file of dir and returns its name.
+func loadSnapshot(snapshot, dir string) (string, error) {
+	in, err := os.Open(snapshot)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	br := bufio.NewReader(in)
+	if head, _ := br.Peek(aes.StreamHeaderSize); aes.IsStream(head) {
+		key, ok, err := secretKey()
+		if err == nil && !ok {
+			err = fmt.Errorf("the snapshot is encrypted: the secret phrase is required")
+		}
+		if err != nil {
+			return "", err
+		}
+
+		dec, err := aes.NewReader(br, key)
+		if err != nil {
+			return "", err
+		}
+		br = bufio.NewReader(dec)
+	}
+
+	var r io.Reader = br
+	if head, _ := br.Peek(len(gzipMagic)); bytes.Equal(head, gzipMagic) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return "", err
+		}
+		r = zr
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	out, err := os.CreateTemp(dir, ".restore-*")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+
+	return out.Name(), nil
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lucasepe/kvs/internal/store"
+	"github.com/lucasepe/toolbox/flags/commander"
+)
+
+func newCmdCheck() *cmdCheck {
+	return &cmdCheck{}
+}
+
+type cmdCheck struct {
+	store   string
+	decrypt bool
+	repair  bool
+	output  string
+}
+
+func (*cmdCheck) Name() string { return "check" }
+func (*cmdCheck) Synopsis() string {
+	return "Verify the integrity of a store."
+}
+func (*cmdCheck) Usage() string {
+	return strings.ReplaceAll(`{NAME} check [-s store] [-d] [-repair [-o file]]
+
+   Check the default store file and all its values:
+     {NAME} check
+
+   Authenticate the encrypted values too:
+     {NAME} check -d
+
+   Copy all the readable values into a fresh store:
+     {NAME} check -d -repair -o secrets.repaired.kvs
+
+   Exits with a non-zero status if any problem is found.`, "{NAME}", appName)
+}
+
+func (p *cmdCheck) SetFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&p.decrypt, "d", false, "authenticate the encrypted values")
+	fs.BoolVar(&p.repair, "repair", false, "copy the readable values into a fresh store")
+	fs.StringVar(&p.output, "o", "", "repaired storage file (default: <store>.repaired.kvs)")
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdCheck) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	var key []byte
+	if p.decrypt {
+		k, ok, err := secretKey()
+		if err == nil && !ok {
+			err = fmt.Errorf("the secret phrase is required to authenticate the values")
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return commander.ExitFailure
+		}
+		key = k
+	}
+
+	db, err := openStore(store.Options{
+		Path:     p.store,
+		Key:      key,
+		ReadOnly: true,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+	defer db.Close()
+
+	report, err := db.Check()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	for _, el := range report.Problems {
+		fmt.Println(el)
+	}
+	fmt.Printf("%d keys checked, %d problems\n", report.Keys, len(report.Problems))
+	if report.Unverified > 0 {
+		fmt.Printf("%d encrypted values not authenticated, use -d to verify them\n", report.Unverified)
+	}
+
+	if p.repair {
+		if err := p.repairTo(db); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return commander.ExitFailure
+		}
+	}
+
+	if len(report.Problems) > 0 {
+		return commander.ExitFailure
+	}
+
+	return commander.ExitSuccess
+}
+
+func (p *cmdCheck) repairTo(db *store.Store) error {
+	if len(p.output) == 0 {
+		p.output = strings.TrimSuffix(p.store, ".kvs") + ".repaired.kvs"
+	}
+	if _, err := os.Stat(p.output); err == nil {
+		return fmt.Errorf("'%s' already exists", p.output)
+	}
+
+	n, err := db.Repair(p.output)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d values copied to '%s'\n", n, p.output)
+	return nil
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lucasepe/kvs/internal/store"
+	"github.com/lucasepe/toolbox/flags/commander"
+	"github.com/lucasepe/toolbox/slug"
+)
+
+// cmdCollection is the common implementation of the
+// list (lpush, rpush, lpop, lrange) and set (sadd, srem,
+// smembers, sismember) commands.
+type cmdCollection struct {
+	name     string
+	synopsis string
+	usage    string
+	// minArgs is the number of required arguments (key included).
+	minArgs int
+	run     func(db *store.Store, key string, args []string) error
+
+	bucket string
+	store  string
+}
+
+func (p *cmdCollection) Name() string     { return p.name }
+func (p *cmdCollection) Synopsis() string { return p.synopsis }
+func (p *cmdCollection) Usage() string {
+	return strings.ReplaceAll(p.usage, "{NAME}", appName)
+}
+
+func (p *cmdCollection) SetFlags(fs *flag.FlagSet) {
+	bucketFlag(fs, &p.bucket)
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdCollection) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	if err := p.complete(fs); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	db, err := openStore(store.Options{
+		BucketName: p.bucket,
+		Path:       p.store,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+	defer db.Close()
+
+	if err := p.run(db, fs.Arg(0), fs.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	return commander.ExitSuccess
+}
+
+func (p *cmdCollection) complete(fs *flag.FlagSet) error {
+	if len(p.bucket) == 0 {
+		return fmt.Errorf("bucket name is required")
+	}
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("key is required")
+	}
+
+	if fs.NArg() < p.minArgs {
+		return fmt.Errorf("not enough arguments, usage: %s", p.Usage())
+	}
+
+	p.bucket = slug.Slugify(p.bucket)
+
+	return nil
+}
+
+func toBytes(args []string) [][]byte {
+	res := make([][]byte, len(args))
+	for i, el := range args {
+		res[i] = []byte(el)
+	}
+	return res
+}
+
+func printItems(items [][]byte) {
+	for _, el := range items {
+		fmt.Printf("%s\n", el)
+	}
+}
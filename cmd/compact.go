@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lucasepe/kvs/internal/store"
+	"github.com/lucasepe/toolbox/flags/commander"
+)
+
+func newCmdCompact() *cmdCompact {
+	return &cmdCompact{}
+}
+
+type cmdCompact struct {
+	store string
+}
+
+func (*cmdCompact) Name() string { return "compact" }
+func (*cmdCompact) Synopsis() string {
+	return "Shrink a store file, releasing the space of the deleted values."
+}
+func (*cmdCompact) Usage() string {
+	return strings.ReplaceAll(`{NAME} compact [-s store]
+
+   Rewrite the default store into a fresh file:
+     {NAME} compact
+
+   Stores are also compacted automatically on close when the
+   free space exceeds the auto-compact setting (e.g. 0.5).`, "{NAME}", appName)
+}
+
+func (p *cmdCompact) SetFlags(fs *flag.FlagSet) {
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdCompact) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	// the explicit compaction makes the automatic one useless
+	db, err := openStore(store.Options{Path: p.store, CompactThreshold: -1})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+	defer db.Close()
+
+	before, after, err := db.Compact()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	var saved int64
+	if before > 0 {
+		saved = (before - after) * 100 / before
+	}
+	fmt.Printf("'%s' compacted: %s -> %s, %d%% saved\n", p.store, formatSize(before), formatSize(after), saved)
+
+	return commander.ExitSuccess
+}
@@ -0,0 +1,296 @@
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lucasepe/kvs/internal/config"
+	"github.com/lucasepe/kvs/internal/pbdk"
+	"github.com/lucasepe/toolbox/flags/commander"
+	"github.com/lucasepe/toolbox/xdg"
+)
+
+const (
+	envProfile     = "KVS_PROFILE"
+	envStore       = "KVS_STORE"
+	envBucket      = "KVS_BUCKET"
+	envLockTimeout = "KVS_LOCK_TIMEOUT"
+)
+
+var (
+	// profile is selected by the global flag or by KVS_PROFILE.
+	profile string
+	// settings are the defaults of the commands, loaded from
+	// the config files for the selected profile.
+	settings config.Settings
+	// sources are the config files of the settings.
+	sources config.Sources
+	// wait is set by the global flag.
+	wait time.Duration
+	// globalFlags are the options set by the global flags.
+	globalFlags = map[string]string{}
+)
+
+// option is a setting of the commands, taken from, in order,
+// its flag, its env var, the config files or its built-in default.
+type option struct {
+	name string
+	env  string
+	conf func() string
+	def  string
+}
+
+var options = []option{
+	{name: "store", env: envStore, conf: func() string { return settings.Store }, def: "secrets"},
+	{name: "bucket", env: envBucket, conf: func() string { return settings.Bucket }},
+	{name: "output", conf: func() string { return settings.Output }, def: "text"},
+	{name: "lock-timeout", env: envLockTimeout, conf: func() string { return settings.LockTimeout }, def: "50ms"},
+	{name: "auto-compact", conf: func() string {
+		if settings.AutoCompact == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(settings.AutoCompact, 'g', -1, 64)
+	}, def: "0"},
+	{name: "kdf.iterations", conf: func() string {
+		if settings.KDF.Iterations == 0 {
+			return ""
+		}
+		return strconv.Itoa(settings.KDF.Iterations)
+	}, def: strconv.Itoa(pbdk.DefaultIterations)},
+	{name: "secret.key-file", conf: func() string { return settings.Secret.KeyFile }},
+	{name: "secret.key-command", conf: func() string { return settings.Secret.KeyCommand }},
+}
+
+// lookup returns the value of the option, ignoring the command
+// flags, and its source.
+func lookup(name string) (value, source string) {
+	for _, el := range options {
+		if el.name != name {
+			continue
+		}
+
+		if fl, ok := globalFlags[name]; ok {
+			return el.conf(), "flag -" + fl
+		}
+		if len(el.env) > 0 {
+			if v := os.Getenv(el.env); len(v) > 0 {
+				return v, "env " + el.env
+			}
+		}
+		if v := el.conf(); len(v) > 0 {
+			return v, sources[name]
+		}
+		return el.def, "default"
+	}
+
+	return "", ""
+}
+
+// loadSettings loads the config files for the profile,
+// which defaults to the KVS_PROFILE env var.
+func loadSettings() error {
+	if len(profile) == 0 {
+		profile = os.Getenv(envProfile)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(configFile(), cwd)
+	if err != nil {
+		return err
+	}
+
+	settings, sources, err = cfg.Settings(profile)
+	if err != nil {
+		return err
+	}
+
+	// the global flags win over the config files
+	if wait > 0 {
+		settings.LockTimeout = wait.String()
+		globalFlags["lock-timeout"] = "wait"
+	}
+	if len(secrets.keyFile) > 0 {
+		settings.Secret.KeyFile = secrets.keyFile
+		globalFlags["secret.key-file"] = "key-file"
+	}
+	if len(secrets.keyCommand) > 0 {
+		settings.Secret.KeyCommand = secrets.keyCommand
+		globalFlags["secret.key-command"] = "key-command"
+	}
+	secrets.keyFile = settings.Secret.KeyFile
+	secrets.keyCommand = settings.Secret.KeyCommand
+
+	return nil
+}
+
+// configFile returns the path of the user config file.
+func configFile() string {
+	return filepath.Join(xdg.ConfigDir(), appName, config.FileName)
+}
+
+// lockTimeout returns how long to wait for a store
+// locked by another process.
+func lockTimeout() (time.Duration, error) {
+	v, src := lookup("lock-timeout")
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid lock timeout '%s' (%s)", v, src)
+	}
+	return d, nil
+}
+
+// autoCompact returns the fraction of free space above
+// which the stores are compacted on close, zero if never.
+func autoCompact() (float64, error) {
+	v, src := lookup("auto-compact")
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || f >= 1 {
+		return 0, fmt.Errorf("invalid auto-compact threshold '%s' (%s)", v, src)
+	}
+	return f, nil
+}
+
+// bucketFlag defines the -b flag of the commands that require a bucket.
+func bucketFlag(fs *flag.FlagSet, p *string) {
+	def, _ := lookup("bucket")
+	fs.StringVar(p, "b", def, "bucket name (required)")
+}
+
+// outputFlag defines the -output flag, text or json.
+func outputFlag(fs *flag.FlagSet, p *string) {
+	def, _ := lookup("output")
+	fs.StringVar(p, "output", def, "output format (text or json)")
+}
+
+// printJSON writes v indented to stdout.
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// checkOutput validates the output format.
+func checkOutput(format string) error {
+	switch format {
+	case "text", "json":
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// deriveKey derives the key from the secret phrase,
+// with the iterations set by the config.
+func deriveKey(sec []byte) ([]byte, error) {
+	if n := settings.KDF.Iterations; n > 0 {
+		return pbdk.DeriveKeyN(sec, n)
+	}
+	return pbdk.DeriveKey(sec)
+}
+
+func newCmdConfig() *cmdGroup {
+	return &cmdGroup{
+		name:     "config",
+		synopsis: "Show the effective configuration.",
+		subs: []commander.Command{
+			&cmdConfigShow{},
+		},
+		def: "show",
+	}
+}
+
+type cmdConfigShow struct {
+	store  string
+	bucket string
+	output string
+}
+
+func (*cmdConfigShow) Name() string { return "show" }
+func (*cmdConfigShow) Synopsis() string {
+	return "Show the effective settings and where they come from."
+}
+func (*cmdConfigShow) Usage() string {
+	return strings.ReplaceAll(`{NAME} config show [-s store] [-b bucket] [-output text|json]
+
+   Show the settings of the 'work' profile:
+     {NAME} --profile work config show
+
+   Each setting is taken from, in order, its flag, its env var
+   (KVS_STORE, KVS_BUCKET), the config files or the built-in default.`, "{NAME}", appName)
+}
+
+func (p *cmdConfigShow) SetFlags(fs *flag.FlagSet) {
+	bucketFlag(fs, &p.bucket)
+	outputFlag(fs, &p.output)
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdConfigShow) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	if err := checkOutput(p.output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	type row struct {
+		Name   string `json:"name"`
+		Value  string `json:"value"`
+		Source string `json:"source"`
+	}
+
+	profileSource := "default"
+	if len(profile) > 0 {
+		profileSource = "env " + envProfile
+		if os.Getenv(envProfile) != profile {
+			profileSource = "flag -profile"
+		}
+	}
+	rows := []row{{"profile", profile, profileSource}}
+
+	local := map[string]string{"store": "s", "bucket": "b", "output": "output"}
+	for _, el := range options {
+		value, source := lookup(el.name)
+		if fl, ok := local[el.name]; ok && set[fl] {
+			source = "flag -" + fl
+		}
+		switch el.name {
+		case "store":
+			value = p.store
+		case "bucket":
+			value = p.bucket
+		case "output":
+			value = p.output
+		}
+		rows = append(rows, row{el.name, value, source})
+	}
+
+	if p.output == "json" {
+		if err := printJSON(rows); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return commander.ExitFailure
+		}
+		return commander.ExitSuccess
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tVALUE\tSOURCE")
+	for _, el := range rows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", el.Name, el.Value, el.Source)
+	}
+	tw.Flush()
+
+	return commander.ExitSuccess
+}
@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lucasepe/kvs/internal/store"
+	"github.com/lucasepe/toolbox/flags/commander"
+	"github.com/lucasepe/toolbox/slug"
+)
+
+func newCmdCopy() *cmdCopy {
+	return &cmdCopy{}
+}
+
+func newCmdMove() *cmdCopy {
+	return &cmdCopy{move: true}
+}
+
+// cmdCopy implements both the 'cp' and the 'mv' commands.
+type cmdCopy struct {
+	move      bool
+	itemKey   string
+	bucket    string
+	store     string
+	dstKey    string
+	dstBucket string
+	dstStore  string
+	force     bool
+}
+
+func (p *cmdCopy) Name() string {
+	if p.move {
+		return "mv"
+	}
+	return "cp"
+}
+
+func (p *cmdCopy) Synopsis() string {
+	if p.move {
+		return "Move a key to another key, bucket or store."
+	}
+	return "Copy a key to another key, bucket or store."
+}
+
+func (p *cmdCopy) Usage() string {
+	verb := "Copy"
+	if p.move {
+		verb = "Move"
+	}
+
+	return strings.NewReplacer("{NAME}", appName, "{CMD}", p.Name(), "{VERB}", verb).
+		Replace(`{NAME} {CMD} [-s store] [-f] -b bucket [-B bucket] [-S store] <key> [new key]
+
+   {VERB} the key 'user' to 'login' in the 'google' bucket:
+     {NAME} {CMD} -b google user login
+
+   {VERB} the key 'user' from the 'google' bucket to the 'work' bucket:
+     {NAME} {CMD} -b google -B work user
+
+   {VERB} the key 'user' to the 'accounts.kvs' store:
+     {NAME} {CMD} -b google -S accounts.kvs user
+
+   Encrypted values are copied as they are, no secret is required.`)
+}
+
+func (p *cmdCopy) SetFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&p.force, "f", false, "overwrite the destination key if it exists")
+	bucketFlag(fs, &p.bucket)
+	fs.StringVar(&p.dstBucket, "B", "", "destination bucket name (default: same bucket)")
+	fs.Var(storeValue{&p.dstStore}, "S", "destination store `name` or file (default: same store)")
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdCopy) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	if err := p.complete(fs); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	db, err := openStore(store.Options{
+		BucketName: p.bucket,
+		Path:       p.store,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+	defer db.Close()
+
+	if p.dstStore == p.store {
+		if p.move {
+			err = db.Move(p.bucket, p.itemKey, p.dstBucket, p.dstKey, p.force)
+		} else {
+			err = db.Copy(p.bucket, p.itemKey, p.dstBucket, p.dstKey, p.force)
+		}
+	} else {
+		err = p.copyToStore(db)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	return commander.ExitSuccess
+}
+
+// copyToStore copies (or moves) the key, with its expiration, into another
+// store file, streaming its raw value one chunk at a time. Two different
+// files cannot share a transaction, so the source key is deleted only
+// after the value has been written to the destination.
+func (p *cmdCopy) copyToStore(src *store.Store) error {
+	at, err := src.ExpiresAt(p.itemKey)
+	if err != nil {
+		return err
+	}
+
+	dst, err := openStore(store.Options{
+		BucketName: p.dstBucket,
+		Path:       p.dstStore,
+	})
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if !p.force {
+		_, err := dst.Stat(p.dstKey)
+		if err == nil {
+			return store.ErrKeyExists
+		}
+		if err != store.ErrBucketNotFound && err != store.ErrKeyNotFound {
+			return err
+		}
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := src.GetRaw(p.itemKey, pw)
+		pw.CloseWithError(err)
+	}()
+	_, err = dst.SetRaw(p.dstKey, pr, 0)
+	pr.Close()
+	if err != nil {
+		return err
+	}
+	// a zero time drops the expiration of an overwritten key
+	if err := dst.Expire(p.dstKey, at); err != nil {
+		return err
+	}
+
+	if p.move {
+		return src.Delete(p.itemKey)
+	}
+
+	return nil
+}
+
+func (p *cmdCopy) complete(fs *flag.FlagSet) error {
+	if len(p.bucket) == 0 {
+		return fmt.Errorf("bucket name is required")
+	}
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("key is required")
+	}
+
+	p.bucket = slug.Slugify(p.bucket)
+	p.itemKey = fs.Arg(0)
+
+	p.dstKey = p.itemKey
+	if fs.NArg() > 1 {
+		p.dstKey = fs.Arg(1)
+	}
+
+	if len(p.dstBucket) == 0 {
+		p.dstBucket = p.bucket
+	}
+	p.dstBucket = slug.Slugify(p.dstBucket)
+
+	if len(p.dstStore) == 0 {
+		p.dstStore = p.store
+	}
+
+	if sameFile(p.store, p.dstStore) {
+		p.dstStore = p.store
+		if p.bucket == p.dstBucket && p.itemKey == p.dstKey {
+			return fmt.Errorf("source and destination are the same")
+		}
+	}
+
+	return nil
+}
+
+// sameFile reports whether the two paths refer to the same file.
+func sameFile(a, b string) bool {
+	if a == b {
+		return true
+	}
+
+	fa, err := os.Stat(a)
+	if err != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	fb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(fa, fb)
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/lucasepe/kvs/internal/store"
+)
+
+// migrateLegacyValue converts a base64 encoded ciphertext written by
+// the older versions to a raw value, as stored by Set. The ciphertext is
+// always authenticated with the key, so plain values that only look
+// like base64 are never touched.
+func migrateLegacyValue(dat, key []byte) ([]byte, error) {
+	src, err := store.DecryptLegacy(dat, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w, err := store.NewEncoder(&buf, key, store.NoCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// newStore opens the store waiting for the lock as configured,
+// with friendly errors for missing or locked stores.
+func newStore(opts store.Options) (*store.Store, error) {
+	if opts.Timeout == 0 {
+		d, err := lockTimeout()
+		if err != nil {
+			return nil, err
+		}
+		opts.Timeout = d
+	}
+
+	db, err := store.New(opts)
+	if errors.Is(err, store.ErrLocked) {
+		return nil, fmt.Errorf("store '%s' is locked by another process (use --wait or %s to wait longer)", opts.Path, envLockTimeout)
+	}
+	if opts.ReadOnly && errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("store '%s' not found", opts.Path)
+	}
+	return db, err
+}
+
+// openStore opens the store and, if its names are hidden,
+// unlocks it with the secret phrase.
+func openStore(opts store.Options) (*store.Store, error) {
+	if opts.CompactThreshold == 0 {
+		t, err := autoCompact()
+		if err != nil {
+			return nil, err
+		}
+		opts.CompactThreshold = t
+	}
+
+	db, err := newStore(opts)
+	if errors.Is(err, store.ErrOutdated) {
+		return nil, fmt.Errorf("%w: run '%s migrate -s %s' to upgrade it", err, appName, opts.Path)
+	}
+	if errors.Is(err, store.ErrTooNew) {
+		return nil, fmt.Errorf("%w: upgrade %s to use it", err, appName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !db.Locked() {
+		return db, nil
+	}
+
+	key, ok, err := secretKey()
+	if err == nil && !ok {
+		err = fmt.Errorf("the store names are hidden: the secret phrase is required")
+	}
+	if err == nil {
+		err = db.Unlock(key)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -38,11 +38,7 @@ func (*cmdDelete) Usage() string {
 func (p *cmdDelete) SetFlags(fs *flag.FlagSet) {
 	fs.StringVar(&p.bucket, "b", "", "bucket name")
 	fs.StringVar(&p.itemKey, "k", "", "key (required)")
-	if def, err := defaultStoreFile(); err == nil {
-		fs.StringVar(&p.store, "s", def, fmt.Sprintf("storage file (default: %s)", def))
-	} else {
-		fs.StringVar(&p.store, "s", "", "storage file (required)")
-	}
+	storeFlag(fs, &p.store)
 }
 
 func (p *cmdDelete) Execute(fs *flag.FlagSet) commander.ExitStatus {
@@ -51,33 +47,41 @@ func (p *cmdDelete) Execute(fs *flag.FlagSet) commander.ExitStatus {
 		return commander.ExitFailure
 	}
 
-	db, err := store.New(store.Options{
-		BucketName: p.bucket,
-		Path:       p.store,
-	})
-	if err != nil {
+	if err := p.delete(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return commander.ExitFailure
 	}
-	defer db.Close()
 
-	if len(p.itemKey) == 0 {
-		err := db.DeleteBucket(p.bucket)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			return commander.ExitFailure
-		}
+	return commander.ExitSuccess
+}
 
-		return commander.ExitSuccess
+// delete deletes the key or the bucket, through
+// the server if KVS_ADDR is set.
+func (p *cmdDelete) delete() error {
+	c, remote, err := remoteClient()
+	if err != nil {
+		return err
+	}
+	if remote {
+		if len(p.itemKey) == 0 {
+			return c.DeleteBucket(p.bucket)
+		}
+		return c.Delete(p.bucket, p.itemKey)
 	}
 
-	err = db.Delete(p.itemKey)
+	db, err := openStore(store.Options{
+		BucketName: p.bucket,
+		Path:       p.store,
+	})
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return commander.ExitFailure
+		return err
 	}
+	defer db.Close()
 
-	return commander.ExitSuccess
+	if len(p.itemKey) == 0 {
+		return db.DeleteBucket(p.bucket)
+	}
+	return db.Delete(p.itemKey)
 }
 
 func (p *cmdDelete) complete(fs *flag.FlagSet) error {
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package cmd
+
+import (
+	"os/exec"
+)
+
+// detach does nothing: the agent stops with the terminal it was started from.
+func detach(c *exec.Cmd) {}
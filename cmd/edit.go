@@ -0,0 +1,273 @@
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/lucasepe/kvs/internal/store"
+	"github.com/lucasepe/toolbox/flags/commander"
+	"github.com/lucasepe/toolbox/slug"
+)
+
+const (
+	defaultEditor = "vi"
+	// shmDir is a memory backed file system available on most
+	// Linux systems: temporary files created here never hit the disk.
+	shmDir = "/dev/shm"
+)
+
+func newCmdEdit() *cmdEdit {
+	return &cmdEdit{}
+}
+
+type cmdEdit struct {
+	itemKey  string
+	bucket   string
+	store    string
+	encrypt  bool
+	compress bool
+}
+
+func (*cmdEdit) Name() string { return "edit" }
+func (*cmdEdit) Synopsis() string {
+	return "Modify a value using your $EDITOR."
+}
+func (*cmdEdit) Usage() string {
+	return strings.ReplaceAll(`{NAME} edit [-s store] [-e] [-z] -b bucket <key>
+
+   Edit the value of the key 'config' of the 'app' bucket:
+     {NAME} edit -b app config
+
+   Edit the key 'notes' of the 'google' bucket, encrypting it:
+     {NAME} edit -e -b google notes
+
+   The value is saved back only if its content changed.
+   Encrypted and compressed values are decoded before editing and
+   encoded again after; the temporary file is wiped and removed as soon as the
+   editor exits.`, "{NAME}", appName)
+}
+
+func (p *cmdEdit) SetFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&p.encrypt, "e", false, "encrypt the value")
+	fs.BoolVar(&p.compress, "z", false, "compress the value (gzip)")
+	bucketFlag(fs, &p.bucket)
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdEdit) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	if err := p.complete(fs); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	if err := p.edit(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	return commander.ExitSuccess
+}
+
+func (p *cmdEdit) edit() error {
+	var key []byte
+	if p.encrypt {
+		var err error
+		if key, err = p.secretKey(); err != nil {
+			return err
+		}
+	}
+
+	// The store is not kept open while the editor is running,
+	// so other commands can use it in the meantime.
+	orig, err := p.load()
+	if err != nil {
+		return err
+	}
+	if kind := store.KindOf(orig); kind != store.KindBlob {
+		return fmt.Errorf("'%s' holds a %s, use the %s commands to modify it", p.itemKey, kind, kind)
+	}
+
+	if key == nil && store.IsEncrypted(orig) {
+		if key, err = p.secretKey(); err != nil {
+			return err
+		}
+	}
+
+	opts := store.Options{
+		BucketName: p.bucket,
+		Path:       p.store,
+		Key:        key,
+	}
+	if p.compress {
+		opts.Compression = store.Gzip
+	}
+
+	db, err := openStore(opts)
+	if err != nil {
+		return err
+	}
+
+	// the value keeps its encryption and compression
+	enc, err := db.EncodingOf(orig)
+	if err == nil {
+		opts.Encrypt = p.encrypt || enc.Encrypted
+		if enc.Compression != store.NoCompression {
+			opts.Compression = enc.Compression
+		}
+	}
+
+	var src []byte
+	if err == nil {
+		src, err = db.Decode(orig)
+	}
+	db.Close()
+	if err != nil {
+		return fmt.Errorf("unable to decode '%s': %w", p.itemKey, err)
+	}
+
+	dat, err := editInTempFile(src)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(src, dat) {
+		fmt.Fprintln(os.Stderr, "no changes")
+		return nil
+	}
+
+	db, err = openStore(opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if dat, err = db.Encode(dat); err != nil {
+		return err
+	}
+
+	err = db.CompareAndSet(p.itemKey, orig, dat)
+	if err == store.ErrConflict {
+		return fmt.Errorf("'%s' has been modified while you were editing it, changes discarded", p.itemKey)
+	}
+
+	return err
+}
+
+func (p *cmdEdit) secretKey() ([]byte, error) {
+	key, ok, err := secretKey()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("the secret phrase is required to edit an encrypted value")
+	}
+	return key, nil
+}
+
+// load returns the value as stored, nil if it does not exist.
+func (p *cmdEdit) load() ([]byte, error) {
+	db, err := openStore(store.Options{
+		BucketName: p.bucket,
+		Path:       p.store,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var buf bytes.Buffer
+	_, err = db.GetRaw(p.itemKey, &buf)
+	if err == store.ErrBucketNotFound || err == store.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (p *cmdEdit) complete(fs *flag.FlagSet) error {
+	if len(p.bucket) == 0 {
+		return fmt.Errorf("bucket name is required")
+	}
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("key is required")
+	}
+
+	p.bucket = slug.Slugify(p.bucket)
+	p.itemKey = fs.Arg(0)
+
+	return nil
+}
+
+// editInTempFile writes the data into a private temporary file,
+// opens it with the user's editor and returns the edited content.
+func editInTempFile(dat []byte) ([]byte, error) {
+	dir := ""
+	if fi, err := os.Stat(shmDir); err == nil && fi.IsDir() {
+		dir = shmDir
+	}
+
+	fp, err := os.CreateTemp(dir, appName+"-*")
+	if err != nil {
+		return nil, err
+	}
+	defer wipeFile(fp.Name())
+
+	if err := fp.Chmod(0600); err != nil {
+		fp.Close()
+		return nil, err
+	}
+
+	if _, err := fp.Write(dat); err != nil {
+		fp.Close()
+		return nil, err
+	}
+
+	if err := fp.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := runEditor(fp.Name()); err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(fp.Name())
+}
+
+func runEditor(filename string) error {
+	editor := os.Getenv("VISUAL")
+	if len(editor) == 0 {
+		editor = os.Getenv("EDITOR")
+	}
+	if len(editor) == 0 {
+		editor = defaultEditor
+	}
+
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], filename)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// wipeFile overwrites the content of the file with zeros before removing it.
+func wipeFile(filename string) {
+	if fi, err := os.Stat(filename); err == nil {
+		if fp, err := os.OpenFile(filename, os.O_WRONLY, 0); err == nil {
+			fp.Write(make([]byte, fi.Size()))
+			fp.Sync()
+			fp.Close()
+		}
+	}
+
+	os.Remove(filename)
+}
@@ -1,15 +1,17 @@
 package cmd
 
 import (
+	"bufio"
 	"encoding/base64"
-	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
-	"github.com/lucasepe/kvs/internal/aes"
-	"github.com/lucasepe/kvs/internal/pbdk"
+	"github.com/lucasepe/kvs/internal/server"
 	"github.com/lucasepe/kvs/internal/store"
 	"github.com/lucasepe/toolbox/flags/commander"
 	"github.com/lucasepe/toolbox/slug"
@@ -20,10 +22,13 @@ func newCmdGet() *cmdGet {
 }
 
 type cmdGet struct {
-	itemKey string
-	bucket  string
-	store   string
-	decrypt bool
+	itemKey  string
+	bucket   string
+	store    string
+	decrypt  bool
+	raw      bool
+	encoding string
+	key      []byte
 }
 
 func (*cmdGet) Name() string { return "get" }
@@ -31,20 +36,24 @@ func (*cmdGet) Synopsis() string {
 	return "Retrieve a value from a bucket."
 }
 func (*cmdGet) Usage() string {
-	return strings.ReplaceAll(`{NAME} [-s store] [-d] -b bucket <key>
+	return strings.ReplaceAll(`{NAME} get [-s store] [-d | -raw [-encoding base64|hex]] -b bucket <key>
 
    Get the value of the key 'user' from the 'google' bucket:
-     {NAME} get -b google user`, "{NAME}", appName)
+     {NAME} get -b google user
+
+   Get the decrypted value of the key 'pass' from the 'google' bucket:
+     {NAME} get -d -b google pass
+
+   Print the ciphertext of the key 'pass' encoded as base64:
+     {NAME} get -raw -encoding base64 -b google pass`, "{NAME}", appName)
 }
 
 func (p *cmdGet) SetFlags(fs *flag.FlagSet) {
 	fs.BoolVar(&p.decrypt, "d", false, "decrypt the value")
-	fs.StringVar(&p.bucket, "b", "", "bucket name (required)")
-	if def, err := defaultStoreFile(); err == nil {
-		fs.StringVar(&p.store, "s", def, fmt.Sprintf("storage file (default: %s)", def))
-	} else {
-		fs.StringVar(&p.store, "s", "", "storage file (required)")
-	}
+	fs.BoolVar(&p.raw, "raw", false, "print the value as stored, even if encrypted")
+	fs.StringVar(&p.encoding, "encoding", "", "encoding of the raw value (base64 or hex)")
+	bucketFlag(fs, &p.bucket)
+	storeFlag(fs, &p.store)
 }
 
 func (p *cmdGet) Execute(fs *flag.FlagSet) commander.ExitStatus {
@@ -53,30 +62,35 @@ func (p *cmdGet) Execute(fs *flag.FlagSet) commander.ExitStatus {
 		return commander.ExitFailure
 	}
 
-	db, err := store.New(store.Options{
-		BucketName: p.bucket,
-		Path:       p.store,
-	})
+	c, remote, err := remoteClient()
+	if err == nil && remote {
+		err = p.printRemote(c)
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return commander.ExitFailure
 	}
-	defer db.Close()
+	if remote {
+		return commander.ExitSuccess
+	}
 
-	data, err := db.Get(p.itemKey)
+	db, err := openStore(store.Options{
+		BucketName: p.bucket,
+		Path:       p.store,
+		ReadOnly:   true,
+		Key:        p.key,
+	})
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return commander.ExitFailure
 	}
+	defer db.Close()
 
-	res, err := p.decryptEventually(data)
-	if err != nil {
+	if err := p.print(db); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return commander.ExitFailure
 	}
 
-	binary.Write(os.Stdout, binary.LittleEndian, res)
-
 	return commander.ExitSuccess
 }
 
@@ -92,30 +106,172 @@ func (p *cmdGet) complete(fs *flag.FlagSet) error {
 	p.bucket = slug.Slugify(p.bucket)
 	p.itemKey = fs.Arg(0)
 
+	switch p.encoding {
+	case "", "base64", "hex":
+	default:
+		return fmt.Errorf("unsupported encoding: %s", p.encoding)
+	}
+
+	if len(p.encoding) > 0 && !p.raw {
+		return fmt.Errorf("-encoding can be used only with -raw")
+	}
+
+	if p.raw && p.decrypt {
+		return fmt.Errorf("-raw and -d cannot be used together")
+	}
+
+	if p.decrypt {
+		var ok bool
+		var err error
+		p.key, ok, err = secretKey()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("the secret phrase is required to decrypt the value")
+		}
+	}
+
 	return nil
 }
 
-func (p *cmdGet) decryptEventually(dat []byte) ([]byte, error) {
-	if !p.decrypt {
-		return dat, nil
+// print writes the value to stdout; large values are
+// streamed without loading them in memory.
+func (p *cmdGet) print(db *store.Store) error {
+	info, err := db.Stat(p.itemKey)
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Kind != store.KindBlob {
+		data, err := db.Get(p.itemKey)
+		if err != nil {
+			return err
+		}
+		// returned as stored without the key
+		if !store.IsEncrypted(data) {
+			return printCollection(data)
+		}
+		if !p.raw {
+			return fmt.Errorf("'%s' is encrypted: use -d to decrypt it or -raw to print the ciphertext", p.itemKey)
+		}
+	}
+
+	if p.raw {
+		return p.printRaw(func(w io.Writer) error {
+			_, err := db.GetRaw(p.itemKey, w)
+			return err
+		})
+	}
+
+	head, err := db.Peek(p.itemKey, 1)
+	if err != nil {
+		return err
+	}
+
+	if store.IsEncrypted(head) && !p.decrypt {
+		return fmt.Errorf("'%s' is encrypted: use -d to decrypt it or -raw to print the ciphertext", p.itemKey)
+	}
+
+	// values encrypted by older versions are small and carry no marker
+	if p.decrypt && info.Chunks == 0 && !store.IsEncrypted(head) {
+		data, err := db.Get(p.itemKey)
+		if err != nil {
+			return err
+		}
+		if store.LooksLegacyEncrypted(data) {
+			res, err := store.DecryptLegacy(data, p.key)
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(res)
+			return err
+		}
+	}
+
+	// decrypted (with -d) and decompressed while streaming
+	return db.GetStream(p.itemKey, os.Stdout)
+}
+
+// printRemote writes the value fetched from the server to stdout,
+// decrypted (with -d) and decompressed on this side.
+func (p *cmdGet) printRemote(c *server.Client) error {
+	body, err := c.Get(p.bucket, p.itemKey)
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	br := bufio.NewReader(body)
+	head, _ := br.Peek(1)
+
+	// encrypted lists and sets are printed as stored with -raw
+	if p.raw && (store.KindOf(head) == store.KindBlob || store.IsEncrypted(head)) {
+		return p.printRaw(func(w io.Writer) error {
+			_, err := io.Copy(w, br)
+			return err
+		})
 	}
 
-	sec, ok := os.LookupEnv(envSecret)
-	if !ok || len(sec) == 0 {
-		return dat, nil
+	if store.IsEncrypted(head) && !p.decrypt {
+		return fmt.Errorf("'%s' is encrypted: use -d to decrypt it or -raw to print the ciphertext", p.itemKey)
 	}
 
-	key, err := pbdk.DeriveKey([]byte(sec))
+	r, err := store.NewDecoder(br, p.key)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	if store.KindOf(head) != store.KindBlob {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return printCollection(data)
+	}
+
+	_, err = io.Copy(os.Stdout, r)
+	return err
+}
+
+// printRaw writes the value as stored, eventually encoded,
+// copying it with the raw function.
+func (p *cmdGet) printRaw(raw func(io.Writer) error) error {
+	var out io.Writer = os.Stdout
+
+	switch p.encoding {
+	case "base64":
+		enc := base64.NewEncoder(base64.StdEncoding, os.Stdout)
+		defer fmt.Println()
+		defer enc.Close()
+		out = enc
+	case "hex":
+		defer fmt.Println()
+		out = hex.NewEncoder(os.Stdout)
 	}
 
-	enc := base64.RawStdEncoding
-	buf := make([]byte, enc.DecodedLen(len(dat)))
-	l, err := enc.Decode(buf, dat)
+	return raw(out)
+}
+
+// printCollection renders a list or a set as a JSON array of strings.
+func printCollection(dat []byte) error {
+	items, err := store.DecodeCollection(dat)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	res := make([]string, len(items))
+	for i, el := range items {
+		res[i] = string(el)
 	}
 
-	return aes.GcmDecrypt(buf[:l], key)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
 }
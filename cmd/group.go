@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lucasepe/toolbox/flags/commander"
+)
+
+// cmdGroup is a command made of subcommands, such as 'recovery split':
+// the first argument selects the subcommand, which parses its own flags.
+// Without arguments the def subcommand runs, if set.
+type cmdGroup struct {
+	name     string
+	synopsis string
+	subs     []commander.Command
+	def      string
+}
+
+func (g *cmdGroup) Name() string     { return g.name }
+func (g *cmdGroup) Synopsis() string { return g.synopsis }
+func (g *cmdGroup) Usage() string {
+	var sb strings.Builder
+	for i, sub := range g.subs {
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+		sb.WriteString(sub.Usage())
+	}
+	return sb.String()
+}
+
+func (g *cmdGroup) SetFlags(fs *flag.FlagSet) {}
+
+func (g *cmdGroup) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	args := fs.Args()
+	if len(args) == 0 && len(g.def) > 0 {
+		args = []string{g.def}
+	}
+
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "%s: a subcommand is required\n\n%s\n", g.name, g.Usage())
+		return commander.ExitUsageError
+	}
+
+	for _, sub := range g.subs {
+		if sub.Name() != args[0] {
+			continue
+		}
+
+		sfs := flag.NewFlagSet(g.name+" "+sub.Name(), flag.ContinueOnError)
+		sub.SetFlags(sfs)
+		if err := sfs.Parse(args[1:]); err != nil {
+			return commander.ExitUsageError
+		}
+		return sub.Execute(sfs)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: unknown subcommand '%s'\n", g.name, args[0])
+	return commander.ExitUsageError
+}
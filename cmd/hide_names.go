@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lucasepe/kvs/internal/store"
+	"github.com/lucasepe/toolbox/flags/commander"
+)
+
+func newCmdHideNames() *cmdHideNames {
+	return &cmdHideNames{}
+}
+
+type cmdHideNames struct {
+	store string
+}
+
+func (*cmdHideNames) Name() string { return "hide-names" }
+func (*cmdHideNames) Synopsis() string {
+	return "Encrypt the bucket and key names of a store."
+}
+func (*cmdHideNames) Usage() string {
+	return strings.ReplaceAll(`{NAME} hide-names [-s store]
+
+   Hide the bucket and key names of the default store:
+     {NAME} hide-names
+
+   Buckets and keys are stored under identifiers derived from
+   the secret phrase, which is then required by every command.
+   The conversion cannot be undone.`, "{NAME}", appName)
+}
+
+func (p *cmdHideNames) SetFlags(fs *flag.FlagSet) {
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdHideNames) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	key, ok, err := secretKey()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+	if !ok {
+		fmt.Fprintln(os.Stderr, "the secret phrase is required")
+		return commander.ExitFailure
+	}
+
+	db, err := openStore(store.Options{
+		Path: p.store,
+		Key:  key,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+	defer db.Close()
+
+	if db.HidesNames() {
+		fmt.Fprintf(os.Stderr, "the names of '%s' are already hidden\n", p.store)
+		return commander.ExitSuccess
+	}
+
+	if err := db.HideNames(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	fmt.Printf("bucket and key names of '%s' are now hidden\n", p.store)
+
+	return commander.ExitSuccess
+}
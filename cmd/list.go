@@ -18,6 +18,7 @@ func newCmdList() *cmdList {
 type cmdList struct {
 	bucket string
 	store  string
+	output string
 }
 
 func (*cmdList) Name() string { return "list" }
@@ -25,43 +26,75 @@ func (*cmdList) Synopsis() string {
 	return "List all buckets or all keys in a bucket."
 }
 func (*cmdList) Usage() string {
-	return strings.ReplaceAll(`{NAME} list [-b bucket]
+	return strings.ReplaceAll(`{NAME} list [-s store] [-b bucket] [-output text|json]
 
    List all keys from the 'google' bucket:
      {NAME} list -b google
 
-   List all buckets:
-     {NAME} list`, "{NAME}", appName)
+   List all buckets as a JSON array:
+     {NAME} list -output json`, "{NAME}", appName)
 }
 
 func (p *cmdList) SetFlags(fs *flag.FlagSet) {
 	fs.StringVar(&p.bucket, "b", "", "bucket name")
-	if def, err := defaultStoreFile(); err == nil {
-		fs.StringVar(&p.store, "s", def, fmt.Sprintf("storage file (default: %s)", def))
-	} else {
-		fs.StringVar(&p.store, "s", "", "storage file (required)")
-	}
+	outputFlag(fs, &p.output)
+	storeFlag(fs, &p.store)
 }
 
 func (p *cmdList) Execute(fs *flag.FlagSet) commander.ExitStatus {
-	db, err := store.New(store.Options{
-		BucketName: p.bucket,
-		Path:       p.store,
-	})
+	if err := checkOutput(p.output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	names, err := p.names()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return commander.ExitFailure
 	}
-	defer db.Close()
 
-	var names []string
-	if len(p.bucket) == 0 {
-		names = db.Buckets()
-	} else {
-		names = db.Keys()
+	if p.output == "json" {
+		if names == nil {
+			names = []string{}
+		}
+		if err := printJSON(names); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return commander.ExitFailure
+		}
+		return commander.ExitSuccess
 	}
 
 	textcol.PrintColumns(os.Stdout, &names, 3)
 
 	return commander.ExitSuccess
 }
+
+// names returns the buckets, or the keys of the bucket,
+// asking the server if KVS_ADDR is set.
+func (p *cmdList) names() ([]string, error) {
+	c, remote, err := remoteClient()
+	if err != nil {
+		return nil, err
+	}
+	if remote {
+		if len(p.bucket) == 0 {
+			return c.Buckets()
+		}
+		return c.Keys(p.bucket)
+	}
+
+	db, err := openStore(store.Options{
+		BucketName: p.bucket,
+		Path:       p.store,
+		ReadOnly:   true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	if len(p.bucket) == 0 {
+		return db.Buckets(), nil
+	}
+	return db.Keys(), nil
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/lucasepe/kvs/internal/store"
+)
+
+func newCmdLPush() *cmdCollection {
+	return &cmdCollection{
+		name:     "lpush",
+		synopsis: "Prepend one or more values to a list.",
+		usage: `{NAME} lpush [-s store] -b bucket <key> <value> [value...]
+
+   Prepend two hosts to the 'web' list of the 'hosts' bucket:
+     {NAME} lpush -b hosts web 10.0.0.1 10.0.0.2`,
+		minArgs: 2,
+		run: func(db *store.Store, key string, args []string) error {
+			n, err := db.LPush(key, toBytes(args)...)
+			if err != nil {
+				return err
+			}
+			fmt.Println(n)
+			return nil
+		},
+	}
+}
+
+func newCmdRPush() *cmdCollection {
+	return &cmdCollection{
+		name:     "rpush",
+		synopsis: "Append one or more values to a list.",
+		usage: `{NAME} rpush [-s store] -b bucket <key> <value> [value...]
+
+   Append two hosts to the 'web' list of the 'hosts' bucket:
+     {NAME} rpush -b hosts web 10.0.0.1 10.0.0.2`,
+		minArgs: 2,
+		run: func(db *store.Store, key string, args []string) error {
+			n, err := db.RPush(key, toBytes(args)...)
+			if err != nil {
+				return err
+			}
+			fmt.Println(n)
+			return nil
+		},
+	}
+}
+
+func newCmdLPop() *cmdCollection {
+	return &cmdCollection{
+		name:     "lpop",
+		synopsis: "Remove and print the first element of a list.",
+		usage: `{NAME} lpop [-s store] -b bucket <key>
+
+   Pop the first host from the 'web' list of the 'hosts' bucket:
+     {NAME} lpop -b hosts web`,
+		minArgs: 1,
+		run: func(db *store.Store, key string, _ []string) error {
+			el, err := db.LPop(key)
+			if err != nil {
+				return err
+			}
+			if el != nil {
+				fmt.Printf("%s\n", el)
+			}
+			return nil
+		},
+	}
+}
+
+func newCmdLRange() *cmdCollection {
+	return &cmdCollection{
+		name:     "lrange",
+		synopsis: "Print a range of elements from a list.",
+		usage: `{NAME} lrange [-s store] -b bucket <key> [start] [stop]
+
+   Print all the hosts of the 'web' list of the 'hosts' bucket:
+     {NAME} lrange -b hosts web
+
+   Print the last two hosts:
+     {NAME} lrange -b hosts web -- -2 -1`,
+		minArgs: 1,
+		run: func(db *store.Store, key string, args []string) error {
+			start, stop := 0, -1
+
+			var err error
+			if len(args) > 0 {
+				if start, err = strconv.Atoi(args[0]); err != nil {
+					return fmt.Errorf("invalid start index: %s", args[0])
+				}
+			}
+			if len(args) > 1 {
+				if stop, err = strconv.Atoi(args[1]); err != nil {
+					return fmt.Errorf("invalid stop index: %s", args[1])
+				}
+			}
+
+			items, err := db.LRange(key, start, stop)
+			if err != nil {
+				return err
+			}
+			printItems(items)
+			return nil
+		},
+	}
+}
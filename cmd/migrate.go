@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lucasepe/kvs/internal/store"
+	"github.com/lucasepe/toolbox/flags/commander"
+)
+
+func newCmdMigrate() *cmdMigrate {
+	return &cmdMigrate{}
+}
+
+type cmdMigrate struct {
+	store string
+	keep  int
+}
+
+func (*cmdMigrate) Name() string { return "migrate" }
+func (*cmdMigrate) Synopsis() string {
+	return "Upgrade a store written by an older version."
+}
+func (*cmdMigrate) Usage() string {
+	return strings.ReplaceAll(`{NAME} migrate [-s store] [-keep n]
+
+   Upgrade the format of the default store:
+     {NAME} migrate
+
+   A timestamped backup of the store is taken first,
+   then each version upgrade runs in its own transaction.`, "{NAME}", appName)
+}
+
+func (p *cmdMigrate) SetFlags(fs *flag.FlagSet) {
+	fs.IntVar(&p.keep, "keep", defaultBackupKeep, "number of timestamped backups to keep")
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdMigrate) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	if err := p.migrate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	return commander.ExitSuccess
+}
+
+func (p *cmdMigrate) migrate() error {
+	if _, err := os.Stat(p.store); err != nil {
+		return err
+	}
+
+	db, err := openStore(store.Options{Path: p.store, Outdated: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if db.Version() == store.SchemaVersion {
+		fmt.Printf("'%s' is up to date (format version %d)\n", p.store, db.Version())
+		return nil
+	}
+
+	prev := backupFile(p.store, time.Now())
+	if err := saveSnapshot(db, prev, false, nil); err != nil {
+		return err
+	}
+	if err := rotateBackups(p.store, p.keep); err != nil {
+		return err
+	}
+	fmt.Printf("store saved to '%s'\n", prev)
+
+	from, to, err := db.Migrate()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("'%s' migrated from format version %d to %d\n", p.store, from, to)
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lucasepe/kvs/internal/store"
+	"github.com/lucasepe/toolbox/flags/commander"
+	"github.com/lucasepe/toolbox/slug"
+)
+
+func newCmdMigrateCiphertext() *cmdMigrateCiphertext {
+	return &cmdMigrateCiphertext{}
+}
+
+type cmdMigrateCiphertext struct {
+	bucket string
+	store  string
+	dryRun bool
+}
+
+func (*cmdMigrateCiphertext) Name() string { return "migrate-ciphertext" }
+func (*cmdMigrateCiphertext) Synopsis() string {
+	return "Convert the base64 encrypted values of older versions to raw bytes."
+}
+func (*cmdMigrateCiphertext) Usage() string {
+	return strings.ReplaceAll(`{NAME} migrate-ciphertext [-s store] [-b bucket] [-n]
+
+   Convert all the encrypted values of the default store:
+     {NAME} migrate-ciphertext
+
+   Show how many values of the 'google' bucket would be converted:
+     {NAME} migrate-ciphertext -n -b google
+
+   The secret phrase is required: only the values that can be
+   authenticated with it are converted, in a single transaction.`, "{NAME}", appName)
+}
+
+func (p *cmdMigrateCiphertext) SetFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&p.dryRun, "n", false, "only count the values to convert")
+	fs.StringVar(&p.bucket, "b", "", "bucket name (default: all buckets)")
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdMigrateCiphertext) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	key, ok, err := secretKey()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+	if !ok {
+		fmt.Fprintln(os.Stderr, "the secret phrase is required")
+		return commander.ExitFailure
+	}
+
+	if len(p.bucket) > 0 {
+		p.bucket = slug.Slugify(p.bucket)
+	}
+
+	db, err := openStore(store.Options{
+		BucketName: p.bucket,
+		Path:       p.store,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+	defer db.Close()
+
+	var converted, skipped int
+	convert := func(bucket, k string, value []byte) ([]byte, bool, error) {
+		if store.KindOf(value) != store.KindBlob || store.IsEncrypted(value) || store.IsCompressed(value) {
+			return nil, false, nil
+		}
+		dat, err := db.Decode(value)
+		if err != nil || !store.LooksLegacyEncrypted(dat) {
+			return nil, false, nil
+		}
+
+		res, err := migrateLegacyValue(dat, key)
+		if err != nil {
+			skipped++
+			return nil, false, nil
+		}
+
+		converted++
+		return res, !p.dryRun, nil
+	}
+
+	if _, err := db.Rewrite(p.bucket, convert); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	verb := "converted"
+	if p.dryRun {
+		verb = "to convert"
+	}
+	fmt.Printf("%d values %s, %d skipped (not encrypted with the current secret)\n", converted, verb, skipped)
+
+	return commander.ExitSuccess
+}
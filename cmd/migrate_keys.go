@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lucasepe/kvs/internal/store"
+	"github.com/lucasepe/toolbox/flags/commander"
+)
+
+func newCmdMigrateKeys() *cmdMigrateKeys {
+	return &cmdMigrateKeys{}
+}
+
+type cmdMigrateKeys struct {
+	store  string
+	policy string
+	dryRun bool
+}
+
+func (*cmdMigrateKeys) Name() string { return "migrate-keys" }
+func (*cmdMigrateKeys) Synopsis() string {
+	return "Set how a store normalizes the keys, renaming the existing ones."
+}
+func (*cmdMigrateKeys) Usage() string {
+	return strings.ReplaceAll(`{NAME} migrate-keys [-s store] [-n] -policy none|slug|lowercase
+
+   Turn all the keys of the default store into slugs, so that
+   'My Key' and 'my-key' are the same key from now on:
+     {NAME} migrate-keys -policy slug
+
+   Show how many keys of the 'work' store would be renamed:
+     {NAME} migrate-keys -s work -n -policy lowercase
+
+   Nothing is changed if different keys of a bucket
+   would become the same key: they are listed instead.`, "{NAME}", appName)
+}
+
+func (p *cmdMigrateKeys) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&p.policy, "policy", "", "key normalization: none, slug or lowercase (required)")
+	fs.BoolVar(&p.dryRun, "n", false, "only count the keys to rename")
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdMigrateKeys) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	if len(p.policy) == 0 {
+		fmt.Fprintln(os.Stderr, "key policy is required")
+		return commander.ExitFailure
+	}
+	policy, err := store.ParseKeyPolicy(p.policy)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	db, err := openStore(store.Options{Path: p.store})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+	defer db.Close()
+
+	res, err := db.MigrateKeys(policy, p.dryRun)
+	for _, el := range res.Collisions {
+		fmt.Fprintf(os.Stderr, "%s/%s: %s\n", el.Bucket, el.Key, strings.Join(el.Keys, ", "))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	if p.dryRun {
+		fmt.Printf("%d keys to rename\n", res.Renamed)
+	} else {
+		fmt.Printf("%d keys renamed, the keys of '%s' are now normalized as '%s'\n", res.Renamed, p.store, policy)
+	}
+
+	return commander.ExitSuccess
+}
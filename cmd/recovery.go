@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lucasepe/kvs/internal/shamir"
+	"github.com/lucasepe/kvs/internal/store"
+	"github.com/lucasepe/toolbox/flags/commander"
+)
+
+// sharePrefix marks the recovery shares printed by 'recovery split'.
+const sharePrefix = "kvs-share:"
+
+func newCmdRecovery() *cmdGroup {
+	return &cmdGroup{
+		name:     "recovery",
+		synopsis: "Split the key in recovery shares or rebuild it from them.",
+		subs: []commander.Command{
+			&cmdRecoverySplit{},
+			&cmdRecoveryCombine{},
+		},
+	}
+}
+
+type cmdRecoverySplit struct {
+	store  string
+	shares int
+	needed int
+	outDir string
+}
+
+func (*cmdRecoverySplit) Name() string { return "split" }
+func (*cmdRecoverySplit) Synopsis() string {
+	return "Split the key in recovery shares."
+}
+func (*cmdRecoverySplit) Usage() string {
+	return strings.ReplaceAll(`{NAME} recovery split [-s store] [-n shares] [-k threshold] [-o dir]
+
+   Split the key in 5 shares, any 3 of them rebuild it:
+     {NAME} recovery split -n 5 -k 3
+
+   Write each share in its own file:
+     {NAME} recovery split -n 5 -k 3 -o ./shares
+
+   The secret phrase is verified first, so the store must
+   hide its names or hold at least an encrypted value.`, "{NAME}", appName)
+}
+
+func (p *cmdRecoverySplit) SetFlags(fs *flag.FlagSet) {
+	fs.IntVar(&p.shares, "n", 5, "number of shares")
+	fs.IntVar(&p.needed, "k", 3, "number of shares required to rebuild the key")
+	fs.StringVar(&p.outDir, "o", "", "write the shares in this directory")
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdRecoverySplit) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	if err := p.split(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	return commander.ExitSuccess
+}
+
+func (p *cmdRecoverySplit) split() error {
+	key, ok, err := secretKey()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("the secret phrase is required")
+	}
+
+	// shares of a wrong key would be useless
+	db, err := openStore(store.Options{Path: p.store, Key: key, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	err = db.CheckKey()
+	db.Close()
+	if errors.Is(err, store.ErrWrongKey) {
+		return fmt.Errorf("the secret phrase does not open '%s'", p.store)
+	}
+	if err != nil {
+		return err
+	}
+
+	shares, err := shamir.Split(key, p.shares, p.needed)
+	if err != nil {
+		return err
+	}
+
+	if len(p.outDir) == 0 {
+		for _, el := range shares {
+			fmt.Println(sharePrefix + hex.EncodeToString(el))
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(p.outDir, 0700); err != nil {
+		return err
+	}
+	for i, el := range shares {
+		fn := filepath.Join(p.outDir, fmt.Sprintf("share-%d.txt", i+1))
+		if err := os.WriteFile(fn, []byte(sharePrefix+hex.EncodeToString(el)+"\n"), 0600); err != nil {
+			return err
+		}
+		fmt.Println(fn)
+	}
+
+	return nil
+}
+
+type cmdRecoveryCombine struct {
+	store string
+}
+
+func (*cmdRecoveryCombine) Name() string { return "combine" }
+func (*cmdRecoveryCombine) Synopsis() string {
+	return "Rebuild the key from the recovery shares and reset the secret phrase."
+}
+func (*cmdRecoveryCombine) Usage() string {
+	return strings.ReplaceAll(`{NAME} recovery combine [-s store] [share files...]
+
+   Rebuild the key from 3 share files and type a new secret phrase:
+     {NAME} recovery combine share-1.txt share-4.txt share-5.txt
+
+   Shares are read from the standard input if no file is given.
+   All the encrypted values are encrypted again with the key
+   derived from the new secret phrase, in a single transaction.`, "{NAME}", appName)
+}
+
+func (p *cmdRecoveryCombine) SetFlags(fs *flag.FlagSet) {
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdRecoveryCombine) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	if err := p.combine(fs.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	return commander.ExitSuccess
+}
+
+func (p *cmdRecoveryCombine) combine(files []string) error {
+	shares, err := readShares(files)
+	if err != nil {
+		return err
+	}
+
+	key, err := shamir.Combine(shares)
+	if err != nil {
+		return err
+	}
+
+	db, err := openStore(store.Options{Path: p.store, Key: key})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	sec, err := readSecret("New secret phrase: ")
+	if err != nil {
+		return err
+	}
+	if len(sec) == 0 {
+		return fmt.Errorf("a terminal is required to type the new secret phrase")
+	}
+	again, err := readSecret("New secret phrase again: ")
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sec, again) {
+		return fmt.Errorf("the secret phrases do not match")
+	}
+
+	newKey, err := deriveKey(sec)
+	if err != nil {
+		return err
+	}
+
+	n, err := db.Rekey(newKey)
+	if err != nil {
+		return fmt.Errorf("the shares do not rebuild the key of '%s': %w", p.store, err)
+	}
+
+	// the agent still holds the old key
+	if c, err := agentClient(); err == nil {
+		c.Lock()
+	}
+
+	fmt.Printf("%d values encrypted with the new secret phrase\n", n)
+	return nil
+}
+
+// readShares reads the shares, one per line, from the files
+// or from the standard input if there are none.
+func readShares(files []string) ([][]byte, error) {
+	var res [][]byte
+	read := func(r io.Reader) error {
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if !strings.HasPrefix(line, sharePrefix) {
+				continue
+			}
+
+			el, err := hex.DecodeString(strings.TrimPrefix(line, sharePrefix))
+			if err != nil {
+				return fmt.Errorf("malformed share: %s", line)
+			}
+			res = append(res, el)
+		}
+		return sc.Err()
+	}
+
+	if len(files) == 0 {
+		return res, read(os.Stdin)
+	}
+
+	for _, fn := range files {
+		f, err := os.Open(fn)
+		if err != nil {
+			return nil, err
+		}
+		err = read(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lucasepe/kvs/internal/store"
+	"github.com/lucasepe/toolbox/flags/commander"
+	"github.com/lucasepe/toolbox/slug"
+)
+
+func newCmdRenameBucket() *cmdRenameBucket {
+	return &cmdRenameBucket{}
+}
+
+type cmdRenameBucket struct {
+	from  string
+	to    string
+	store string
+}
+
+func (*cmdRenameBucket) Name() string { return "rename-bucket" }
+func (*cmdRenameBucket) Synopsis() string {
+	return "Rename a bucket."
+}
+func (*cmdRenameBucket) Usage() string {
+	return strings.ReplaceAll(`{NAME} rename-bucket [-s store] <bucket> <new name>
+
+   Rename the 'google' bucket to 'gmail':
+     {NAME} rename-bucket google gmail`, "{NAME}", appName)
+}
+
+func (p *cmdRenameBucket) SetFlags(fs *flag.FlagSet) {
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdRenameBucket) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	if err := p.complete(fs); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	db, err := openStore(store.Options{
+		BucketName: p.from,
+		Path:       p.store,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+	defer db.Close()
+
+	if err := db.RenameBucket(p.from, p.to); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	return commander.ExitSuccess
+}
+
+func (p *cmdRenameBucket) complete(fs *flag.FlagSet) error {
+	if fs.NArg() < 2 {
+		return fmt.Errorf("both the bucket name and the new name are required")
+	}
+
+	p.from = slug.Slugify(fs.Arg(0))
+	p.to = slug.Slugify(fs.Arg(1))
+
+	return nil
+}
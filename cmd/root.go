@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/lucasepe/toolbox/flags/commander"
 	"github.com/lucasepe/toolbox/xdg"
@@ -27,10 +28,49 @@ func Run(ver, bld string) {
 	app.Register(newCmdSet(), "")
 	app.Register(newCmdList(), "")
 	app.Register(newCmdGet(), "")
+	app.Register(newCmdEdit(), "")
 	app.Register(newCmdDelete(), "")
+	app.Register(newCmdCopy(), "")
+	app.Register(newCmdMove(), "")
+	app.Register(newCmdRenameBucket(), "")
+	app.Register(newCmdSearch(), "")
+	app.Register(newCmdStats(), "")
+	app.Register(newCmdCheck(), "")
+	app.Register(newCmdBackup(), "")
+	app.Register(newCmdRestore(), "")
+	app.Register(newCmdCompact(), "")
+	app.Register(newCmdStores(), "")
+	app.Register(newCmdServe(), "")
+	app.Register(newCmdWatch(), "")
+	app.Register(newCmdConfig(), "")
+	app.Register(newCmdMigrate(), "")
+	app.Register(newCmdMigrateCiphertext(), "")
+	app.Register(newCmdMigrateKeys(), "")
+	app.Register(newCmdHideNames(), "")
+	app.Register(newCmdRecovery(), "")
+	app.Register(newCmdAgent(), "agent")
+	app.Register(newCmdUnlock(), "agent")
+	app.Register(newCmdLock(), "agent")
+	app.Register(newCmdLPush(), "lists")
+	app.Register(newCmdRPush(), "lists")
+	app.Register(newCmdLPop(), "lists")
+	app.Register(newCmdLRange(), "lists")
+	app.Register(newCmdSAdd(), "sets")
+	app.Register(newCmdSRem(), "sets")
+	app.Register(newCmdSMembers(), "sets")
+	app.Register(newCmdSIsMember(), "sets")
 
+	flag.StringVar(&secrets.keyFile, "key-file", "", "file holding the 32 bytes encryption key")
+	flag.StringVar(&secrets.keyCommand, "key-command", "", "command that prints the secret phrase")
+	flag.StringVar(&profile, "profile", "", "configuration profile (default: $KVS_PROFILE)")
+	flag.DurationVar(&wait, "wait", 0, "how long to wait for a store locked by another process (default: $KVS_LOCK_TIMEOUT or 50ms)")
 	flag.Parse()
 
+	if err := loadSettings(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(int(commander.ExitFailure))
+	}
+
 	os.Exit(int(app.Execute()))
 }
 
@@ -52,5 +92,50 @@ func storeFile(name string) (string, error) {
 }
 
 func defaultStoreFile() (string, error) {
-	return storeFile("secrets")
+	name, _ := lookup("store")
+	return resolveStore(name)
+}
+
+// resolveStore returns the file of the store: a name, such as 'work',
+// is a store of the store directory, anything with a path separator
+// (e.g. './work.kvs') is a file path.
+func resolveStore(s string) (string, error) {
+	if len(s) == 0 || strings.ContainsRune(s, '/') || strings.ContainsRune(s, filepath.Separator) {
+		return s, nil
+	}
+	return storeFile(s)
+}
+
+// storeValue is a flag.Value that resolves the store names
+// to their files.
+type storeValue struct {
+	path *string
+}
+
+func (v storeValue) String() string {
+	if v.path == nil {
+		return ""
+	}
+	return *v.path
+}
+
+func (v storeValue) Set(s string) error {
+	path, err := resolveStore(s)
+	if err != nil {
+		return err
+	}
+	*v.path = path
+	return nil
+}
+
+// storeFlag defines the -s flag, holding the file of the store.
+func storeFlag(fs *flag.FlagSet, p *string) {
+	def, err := defaultStoreFile()
+	if err != nil {
+		fs.Var(storeValue{p}, "s", "store `name` or file (required)")
+		return
+	}
+
+	*p = def
+	fs.Var(storeValue{p}, "s", fmt.Sprintf("store `name` or file (default: %s)", def))
 }
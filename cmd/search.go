@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/lucasepe/kvs/internal/store"
+	"github.com/lucasepe/toolbox/flags/commander"
+	"github.com/lucasepe/toolbox/slug"
+)
+
+func newCmdSearch() *cmdSearch {
+	return &cmdSearch{}
+}
+
+type cmdSearch struct {
+	pattern    string
+	bucket     string
+	store      string
+	keys       bool
+	values     bool
+	regex      bool
+	ignoreCase bool
+	decrypt    bool
+
+	match func([]byte) bool
+	key   []byte
+}
+
+func (*cmdSearch) Name() string { return "search" }
+func (*cmdSearch) Synopsis() string {
+	return "Search keys and values in all buckets."
+}
+func (*cmdSearch) Usage() string {
+	return strings.ReplaceAll(`{NAME} search [-s store] [-b bucket] [-keys|-values] [-regex] [-i] [-d] <pattern>
+
+   Find which bucket holds the 'example.com' hostname:
+     {NAME} search example.com
+
+   Search only the keys of the 'google' bucket:
+     {NAME} search -b google -keys user
+
+   Search using a regular expression, ignoring case:
+     {NAME} search -regex -i '^db[0-9]+\.'
+
+   Encrypted values are skipped unless -d is specified
+   and the secret phrase is available.`, "{NAME}", appName)
+}
+
+func (p *cmdSearch) SetFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&p.keys, "keys", false, "search only the keys")
+	fs.BoolVar(&p.values, "values", false, "search only the values")
+	fs.BoolVar(&p.regex, "regex", false, "the pattern is a regular expression")
+	fs.BoolVar(&p.ignoreCase, "i", false, "ignore case")
+	fs.BoolVar(&p.decrypt, "d", false, "decrypt the values before searching")
+	fs.StringVar(&p.bucket, "b", "", "bucket name (default: all buckets)")
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdSearch) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	if err := p.complete(fs); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	db, err := openStore(store.Options{
+		BucketName: p.bucket,
+		Path:       p.store,
+		ReadOnly:   true,
+		Key:        p.key,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+	defer db.Close()
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	err = db.Walk(p.bucket, func(bucket, key string, value []byte) error {
+		if p.keys && p.match([]byte(key)) {
+			fmt.Fprintf(out, "%s/%s\n", bucket, key)
+		}
+
+		if p.values {
+			p.searchValue(db, out, bucket, key, value)
+		}
+
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	return commander.ExitSuccess
+}
+
+func (p *cmdSearch) searchValue(db *store.Store, out *bufio.Writer, bucket, key string, value []byte) {
+	var lines [][]byte
+
+	if store.IsEncrypted(value) && p.key == nil {
+		return
+	}
+	kind := store.KindOf(value)
+	value, err := db.Decode(value)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case kind != store.KindBlob:
+		items, err := store.DecodeCollection(value)
+		if err != nil {
+			return
+		}
+		lines = items
+
+	default:
+		if p.key != nil && store.LooksLegacyEncrypted(value) {
+			if dec, err := store.DecryptLegacy(value, p.key); err == nil {
+				value = dec
+			}
+		}
+
+		if bytes.IndexByte(value, 0) != -1 {
+			if p.match(value) {
+				fmt.Fprintf(out, "%s/%s: binary value matches\n", bucket, key)
+			}
+			return
+		}
+		lines = bytes.Split(value, []byte("\n"))
+	}
+
+	for _, line := range lines {
+		if p.match(line) {
+			fmt.Fprintf(out, "%s/%s: %s\n", bucket, key, line)
+		}
+	}
+}
+
+func (p *cmdSearch) complete(fs *flag.FlagSet) error {
+	if fs.NArg() == 0 {
+		return fmt.Errorf("search pattern is required")
+	}
+	p.pattern = fs.Arg(0)
+
+	if len(p.bucket) > 0 {
+		p.bucket = slug.Slugify(p.bucket)
+	}
+
+	if !p.keys && !p.values {
+		p.keys, p.values = true, true
+	}
+
+	if p.decrypt {
+		key, ok, err := secretKey()
+		if err != nil {
+			return err
+		}
+		if ok {
+			p.key = key
+		}
+	}
+
+	expr := p.pattern
+	if !p.regex {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if p.ignoreCase {
+		expr = "(?i)" + expr
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	p.match = re.Match
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"golang.org/x/term"
+)
+
+const keySize = 32
+
+// secrets resolves the encryption key for all the commands,
+// the key file and command are set by the global flags.
+var secrets secretResolver
+
+// secretResolver looks for the encryption key through an ordered
+// chain of sources: the key file flag, the agent, the KVS_SECRET
+// env var, the key command and, at last, the terminal prompt.
+type secretResolver struct {
+	keyFile    string
+	keyCommand string
+
+	key []byte
+}
+
+// secretKey returns the encryption key.
+// The boolean is false if no secret has been supplied.
+func secretKey() ([]byte, bool, error) {
+	return secrets.resolve(true)
+}
+
+// resolve returns the first key found along the chain,
+// skipping the agent if withAgent is false.
+func (r *secretResolver) resolve(withAgent bool) ([]byte, bool, error) {
+	if r.key != nil {
+		return r.key, true, nil
+	}
+
+	sources := []func() ([]byte, error){
+		r.fromKeyFile,
+		r.fromAgent,
+		r.fromEnv,
+		r.fromCommand,
+		r.fromPrompt,
+	}
+	if !withAgent {
+		sources = append(sources[:1], sources[2:]...)
+	}
+
+	for _, src := range sources {
+		key, err := src()
+		if err != nil {
+			return nil, false, err
+		}
+		if key != nil {
+			r.key = key
+			return key, true, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+// fromKeyFile reads the key, raw or hex encoded, from the key file.
+func (r *secretResolver) fromKeyFile() ([]byte, error) {
+	if len(r.keyFile) == 0 {
+		return nil, nil
+	}
+
+	fi, err := os.Stat(r.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("permissions %#o of key file '%s' are too open", fi.Mode().Perm(), r.keyFile)
+	}
+
+	dat, err := os.ReadFile(r.keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dat) == keySize {
+		return dat, nil
+	}
+	if key, err := hex.DecodeString(string(bytes.TrimSpace(dat))); err == nil && len(key) == keySize {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("key file '%s' must hold %d random bytes, raw or hex encoded", r.keyFile, keySize)
+}
+
+func (r *secretResolver) fromAgent() ([]byte, error) {
+	c, err := agentClient()
+	if err != nil {
+		return nil, nil
+	}
+
+	key, err := c.Key()
+	if err != nil {
+		// a locked or stopped agent is not an error,
+		// the next sources are tried
+		return nil, nil
+	}
+	return key, nil
+}
+
+func (r *secretResolver) fromEnv() ([]byte, error) {
+	sec, ok := os.LookupEnv(envSecret)
+	if !ok || len(sec) == 0 {
+		return nil, nil
+	}
+	return deriveKey([]byte(sec))
+}
+
+// fromCommand runs the key command, its output is the secret phrase.
+func (r *secretResolver) fromCommand() ([]byte, error) {
+	if len(r.keyCommand) == 0 {
+		return nil, nil
+	}
+
+	var c *exec.Cmd
+	if runtime.GOOS == "windows" {
+		c = exec.Command("cmd", "/C", r.keyCommand)
+	} else {
+		c = exec.Command("sh", "-c", r.keyCommand)
+	}
+	c.Stdin, c.Stderr = os.Stdin, os.Stderr
+
+	out, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("key command failed: %w", err)
+	}
+
+	sec := bytes.TrimRight(out, "\r\n")
+	if len(sec) == 0 {
+		return nil, fmt.Errorf("key command printed an empty secret phrase")
+	}
+	return deriveKey(sec)
+}
+
+func (r *secretResolver) fromPrompt() ([]byte, error) {
+	sec, err := readSecret("Secret phrase: ")
+	if err != nil || len(sec) == 0 {
+		return nil, err
+	}
+	return deriveKey(sec)
+}
+
+// readSecret prompts for the secret phrase on the terminal, without echo.
+// It returns nil if there is no terminal, so that the standard
+// input can still be used for the values.
+func readSecret(prompt string) ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return nil, nil
+		}
+		tty = os.Stdin
+	} else {
+		defer tty.Close()
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+
+	return term.ReadPassword(int(tty.Fd()))
+}
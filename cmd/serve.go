@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lucasepe/kvs/internal/resp"
+	"github.com/lucasepe/kvs/internal/server"
+	"github.com/lucasepe/kvs/internal/store"
+	"github.com/lucasepe/toolbox/flags/commander"
+)
+
+const (
+	envAddr       = "KVS_ADDR"
+	envToken      = "KVS_TOKEN"
+	envVaultAddr  = "VAULT_ADDR"
+	envVaultToken = "VAULT_TOKEN"
+	defaultListen = "127.0.0.1:7373"
+)
+
+func newCmdServe() *cmdServe {
+	return &cmdServe{}
+}
+
+type cmdServe struct {
+	store   string
+	listen  string
+	resp    string
+	vault   bool
+	encrypt bool
+	token   string
+	maxSize string
+}
+
+func (*cmdServe) Name() string { return "serve" }
+func (*cmdServe) Synopsis() string {
+	return "Share a store through a REST API."
+}
+func (*cmdServe) Usage() string {
+	return strings.ReplaceAll(`{NAME} serve [-s store] [-listen unix:/path | host:port | -resp host:port] [-vault-compat [-e]] [-token token] [-max-size size]
+
+   Serve the default store on 127.0.0.1:7373 with a random token:
+     {NAME} serve
+
+   Serve the 'work' store on a unix socket:
+     KVS_TOKEN=s3cr3t {NAME} serve -s work -listen unix:$XDG_RUNTIME_DIR/kvs/serve.sock
+
+   The commands get, set, del, list and backup talk to the
+   server when KVS_ADDR and KVS_TOKEN are set:
+     KVS_ADDR=unix:$XDG_RUNTIME_DIR/kvs/serve.sock KVS_TOKEN=s3cr3t {NAME} list
+
+   Serve the default store to Redis clients on port 6380:
+     KVS_TOKEN=s3cr3t {NAME} serve -resp :6380
+     redis-cli -p 6380 -a s3cr3t set greeting hello
+
+   Serve the secrets to the Vault clients, as the KV v2 engine mounted at 'secret/':
+     {NAME} serve -vault-compat -token root &
+     VAULT_ADDR=http://127.0.0.1:7373 VAULT_TOKEN=root vault kv get secret/myapp`, "{NAME}", appName)
+}
+
+func (p *cmdServe) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&p.listen, "listen", defaultListen, "address to listen on, 'unix:/path' for a unix socket")
+	fs.StringVar(&p.resp, "resp", "", "speak the Redis protocol (RESP) on this address, instead of the REST API")
+	fs.BoolVar(&p.vault, "vault-compat", false, "serve the HTTP API of the Vault KV v2 secrets engine, instead of the REST API")
+	fs.BoolVar(&p.encrypt, "e", false, "with -vault-compat, encrypt the written secrets and decrypt the read ones")
+	fs.StringVar(&p.token, "token", "", "token required by the requests (default: $KVS_TOKEN or a random one)")
+	fs.StringVar(&p.maxSize, "max-size", defaultMaxSize, "maximum size of the values (e.g. 512K, 64M, 1G; 0 means no limit)")
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdServe) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	if err := p.serve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	return commander.ExitSuccess
+}
+
+func (p *cmdServe) serve() error {
+	limit, err := parseSize(p.maxSize)
+	if err != nil {
+		return err
+	}
+
+	if p.encrypt && !p.vault {
+		return fmt.Errorf("-e can be used only with -vault-compat")
+	}
+
+	addrVar, tokenVar := envAddr, envToken
+	if p.vault {
+		addrVar, tokenVar = envVaultAddr, envVaultToken
+	}
+
+	if len(p.token) == 0 {
+		p.token = os.Getenv(envToken)
+	}
+	if len(p.token) == 0 {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return err
+		}
+		p.token = hex.EncodeToString(buf)
+		fmt.Printf("%s=%s; export %s;\n", tokenVar, p.token, tokenVar)
+	}
+
+	opts := store.Options{Path: p.store}
+	if p.encrypt {
+		key, ok, err := secretKey()
+		if err == nil && !ok {
+			err = fmt.Errorf("the secret phrase is required to encrypt the secrets")
+		}
+		if err != nil {
+			return err
+		}
+		opts.Key, opts.Encrypt = key, true
+	}
+
+	db, err := openStore(opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if len(p.resp) > 0 {
+		return p.serveRESP(db)
+	}
+
+	var handler http.Handler = server.New(db, p.token, limit)
+	addr := p.listen
+	if p.vault {
+		handler = server.NewVault(db, p.token, limit)
+		if !strings.HasPrefix(addr, "unix:") {
+			addr = "http://" + addr
+		}
+	}
+
+	l, err := server.Listen(p.listen)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s=%s; export %s;\n", addrVar, addr, addrVar)
+
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	err = srv.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// serveRESP serves the store to Redis clients: the keys live
+// in the bucket chosen by SELECT, hashes are buckets.
+func (p *cmdServe) serveRESP(db *store.Store) error {
+	l, err := server.Listen(p.resp)
+	if err != nil {
+		return err
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		l.Close()
+	}()
+
+	return resp.New(db, p.token).Serve(l)
+}
+
+// remoteClient returns the client of the server set by KVS_ADDR,
+// false if the commands should use the store directly.
+func remoteClient() (*server.Client, bool, error) {
+	addr := os.Getenv(envAddr)
+	if len(addr) == 0 {
+		return nil, false, nil
+	}
+
+	token := os.Getenv(envToken)
+	if len(token) == 0 {
+		return nil, true, fmt.Errorf("%s is set: %s is required too", envAddr, envToken)
+	}
+	return server.NewClient(addr, token), true, nil
+}
@@ -2,7 +2,7 @@ package cmd
 
 import (
 	"bufio"
-	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -10,15 +10,14 @@ import (
 	"os"
 	"strings"
 
-	"github.com/lucasepe/kvs/internal/aes"
-	"github.com/lucasepe/kvs/internal/pbdk"
+	"github.com/lucasepe/kvs/internal/server"
 	"github.com/lucasepe/kvs/internal/store"
 	"github.com/lucasepe/toolbox/flags/commander"
 	"github.com/lucasepe/toolbox/slug"
 )
 
 const (
-	maxFileSize = 1024 * 1024 // 1 MB
+	defaultMaxSize = "64M"
 )
 
 func newCmdSet() *cmdSet {
@@ -26,10 +25,14 @@ func newCmdSet() *cmdSet {
 }
 
 type cmdSet struct {
-	itemKey string
-	bucket  string
-	store   string
-	encrypt bool
+	itemKey  string
+	bucket   string
+	store    string
+	encrypt  bool
+	compress bool
+	maxSize  string
+	limit    int64
+	key      []byte
 }
 
 func (*cmdSet) Name() string { return "set" }
@@ -37,7 +40,7 @@ func (*cmdSet) Synopsis() string {
 	return "Save a key/value pair to a bucket."
 }
 func (*cmdSet) Usage() string {
-	return strings.ReplaceAll(`{NAME} set [-s store] [-e] -b bucket <key> <value>
+	return strings.ReplaceAll(`{NAME} set [-s store] [-e] [-z] [-max-size size] -b bucket <key> <value>
   
    Save the value 'my@gmail.com' with the key 'user' into the 'google' bucket:
      {NAME} set -b google user my@gmail.com
@@ -46,17 +49,21 @@ func (*cmdSet) Usage() string {
      cat doc.txt | {NAME} set -b google doc
 
    Save a command output using pipes:
-     pwgen 14 1 | {NAME} set -b instagram pass`, "{NAME}", appName)
+     pwgen 14 1 | {NAME} set -b instagram pass
+
+   Save a certificate compressed and encrypted:
+     cat cert.pem | {NAME} set -z -e -b certs example.com
+
+   Save a large file raising the size limit (use 0 for no limit):
+     cat backup.tar | {NAME} set -max-size 1G -b files backup`, "{NAME}", appName)
 }
 
 func (p *cmdSet) SetFlags(fs *flag.FlagSet) {
 	fs.BoolVar(&p.encrypt, "e", false, "encrypt the value")
-	fs.StringVar(&p.bucket, "b", "", "bucket name (required)")
-	if def, err := defaultStoreFile(); err == nil {
-		fs.StringVar(&p.store, "s", def, fmt.Sprintf("storage file (default: %s)", def))
-	} else {
-		fs.StringVar(&p.store, "s", "", "storage file (required)")
-	}
+	fs.BoolVar(&p.compress, "z", false, "compress the value (gzip)")
+	fs.StringVar(&p.maxSize, "max-size", defaultMaxSize, "maximum size of the value (e.g. 512K, 64M, 1G; 0 means no limit)")
+	bucketFlag(fs, &p.bucket)
+	storeFlag(fs, &p.store)
 }
 
 func (p *cmdSet) Execute(fs *flag.FlagSet) commander.ExitStatus {
@@ -66,19 +73,37 @@ func (p *cmdSet) Execute(fs *flag.FlagSet) commander.ExitStatus {
 		return commander.ExitFailure
 	}
 
-	if len(src) == 0 {
+	if _, err := src.Peek(1); err == io.EOF {
 		return commander.ExitSuccess
 	}
 
-	dat, err := p.encryptEventually(src)
+	compression := store.NoCompression
+	if p.compress {
+		compression = store.Gzip
+	}
+
+	c, remote, err := remoteClient()
+	if err == nil && remote {
+		err = p.saveRemote(c, src, compression)
+	}
+	if errors.Is(err, store.ErrTooLarge) {
+		err = fmt.Errorf("the value exceeds the maximum size of %s (see -max-size)", formatSize(p.limit))
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return commander.ExitFailure
 	}
+	if remote {
+		fmt.Printf("value with key '%s' successfully saved to '%s'\n", p.itemKey, os.Getenv(envAddr))
+		return commander.ExitSuccess
+	}
 
-	db, err := store.New(store.Options{
-		BucketName: p.bucket,
-		Path:       p.store,
+	db, err := openStore(store.Options{
+		BucketName:  p.bucket,
+		Path:        p.store,
+		Key:         p.key,
+		Encrypt:     p.encrypt,
+		Compression: compression,
 	})
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -86,17 +111,49 @@ func (p *cmdSet) Execute(fs *flag.FlagSet) commander.ExitStatus {
 	}
 	defer db.Close()
 
-	if err := db.Set(p.itemKey, dat); err != nil {
+	err = db.SetStream(p.itemKey, src, p.limit)
+	if err == store.ErrTooLarge {
+		err = fmt.Errorf("the value exceeds the maximum size of %s (see -max-size)", formatSize(p.limit))
+	}
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return commander.ExitFailure
 	}
 
-	fmt.Printf("value with key '%s' successfully saved to '%s'\n", p.itemKey, p.store)
+	fmt.Printf("value with key '%s' successfully saved to '%s'\n", db.KeyPolicy().Normalize(p.itemKey), p.store)
 
 	return commander.ExitSuccess
 }
 
-func (p *cmdSet) complete(fs *flag.FlagSet) ([]byte, error) {
+// saveRemote sends the value to the server, compressed
+// and encrypted (with -e) on this side.
+func (p *cmdSet) saveRemote(c *server.Client, src io.Reader, compression store.Compression) error {
+	if p.limit > 0 {
+		src = io.LimitReader(src, p.limit+1)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		enc, err := store.NewEncoder(pw, p.key, compression)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		n, err := io.Copy(enc, src)
+		if err == nil && p.limit > 0 && n > p.limit {
+			err = store.ErrTooLarge
+		}
+		if err == nil {
+			err = enc.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+
+	return c.Put(p.bucket, p.itemKey, pr)
+}
+
+func (p *cmdSet) complete(fs *flag.FlagSet) (*bufio.Reader, error) {
 	if len(p.bucket) == 0 {
 		return nil, fmt.Errorf("bucket name is required")
 	}
@@ -108,6 +165,23 @@ func (p *cmdSet) complete(fs *flag.FlagSet) ([]byte, error) {
 	p.bucket = slug.Slugify(p.bucket)
 	p.itemKey = fs.Arg(0)
 
+	var err error
+	p.limit, err = parseSize(p.maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.encrypt {
+		var ok bool
+		p.key, ok, err = secretKey()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("the secret phrase is required to encrypt the value")
+		}
+	}
+
 	var reader io.Reader
 
 	info, err := os.Stdin.Stat()
@@ -116,7 +190,7 @@ func (p *cmdSet) complete(fs *flag.FlagSet) ([]byte, error) {
 	}
 
 	if (info.Mode() & os.ModeCharDevice) != os.ModeCharDevice {
-		reader = io.LimitReader(bufio.NewReader(os.Stdin), maxFileSize)
+		reader = os.Stdin
 	} else if fs.NArg() > 1 {
 		reader = strings.NewReader(fs.Arg(1))
 	}
@@ -125,32 +199,5 @@ func (p *cmdSet) complete(fs *flag.FlagSet) ([]byte, error) {
 		return nil, fmt.Errorf("the value to store has not been specified")
 	}
 
-	return io.ReadAll(reader)
-}
-
-func (p *cmdSet) encryptEventually(dat []byte) ([]byte, error) {
-	if !p.encrypt {
-		return dat, nil
-	}
-
-	sec, ok := os.LookupEnv(envSecret)
-	if !ok || len(sec) == 0 {
-		return dat, nil
-	}
-
-	key, err := pbdk.DeriveKey([]byte(sec))
-	if err != nil {
-		return nil, err
-	}
-
-	src, err := aes.GcmEncrypt(dat, key)
-	if err != nil {
-		return nil, err
-	}
-
-	enc := base64.RawStdEncoding
-	buf := make([]byte, enc.EncodedLen(len(src)))
-	enc.Encode(buf, src)
-
-	return buf, nil
+	return bufio.NewReader(reader), nil
 }
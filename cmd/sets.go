@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/lucasepe/kvs/internal/store"
+)
+
+func newCmdSAdd() *cmdCollection {
+	return &cmdCollection{
+		name:     "sadd",
+		synopsis: "Add one or more members to a set.",
+		usage: `{NAME} sadd [-s store] -b bucket <key> <member> [member...]
+
+   Add two users to the 'admins' set of the 'users' bucket:
+     {NAME} sadd -b users admins alice bob`,
+		minArgs: 2,
+		run: func(db *store.Store, key string, args []string) error {
+			n, err := db.SAdd(key, toBytes(args)...)
+			if err != nil {
+				return err
+			}
+			fmt.Println(n)
+			return nil
+		},
+	}
+}
+
+func newCmdSRem() *cmdCollection {
+	return &cmdCollection{
+		name:     "srem",
+		synopsis: "Remove one or more members from a set.",
+		usage: `{NAME} srem [-s store] -b bucket <key> <member> [member...]
+
+   Remove 'bob' from the 'admins' set of the 'users' bucket:
+     {NAME} srem -b users admins bob`,
+		minArgs: 2,
+		run: func(db *store.Store, key string, args []string) error {
+			n, err := db.SRem(key, toBytes(args)...)
+			if err != nil {
+				return err
+			}
+			fmt.Println(n)
+			return nil
+		},
+	}
+}
+
+func newCmdSMembers() *cmdCollection {
+	return &cmdCollection{
+		name:     "smembers",
+		synopsis: "Print all the members of a set.",
+		usage: `{NAME} smembers [-s store] -b bucket <key>
+
+   Print the members of the 'admins' set of the 'users' bucket:
+     {NAME} smembers -b users admins`,
+		minArgs: 1,
+		run: func(db *store.Store, key string, _ []string) error {
+			items, err := db.SMembers(key)
+			if err != nil {
+				return err
+			}
+			printItems(items)
+			return nil
+		},
+	}
+}
+
+func newCmdSIsMember() *cmdCollection {
+	return &cmdCollection{
+		name:     "sismember",
+		synopsis: "Check if a value is a member of a set.",
+		usage: `{NAME} sismember [-s store] -b bucket <key> <member>
+
+   Check if 'alice' belongs to the 'admins' set of the 'users' bucket:
+     {NAME} sismember -b users admins alice`,
+		minArgs: 2,
+		run: func(db *store.Store, key string, args []string) error {
+			ok, err := db.SIsMember(key, []byte(args[0]))
+			if err != nil {
+				return err
+			}
+			fmt.Println(ok)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var sizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+// parseSize parses a size in bytes with an optional K, M or G suffix
+// (e.g. 512K, 64M, 1G). A size of 0 means no limit.
+func parseSize(s string) (int64, error) {
+	str := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+
+	mult := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(str, u.suffix) {
+			str, mult = strings.TrimSuffix(str, u.suffix), u.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+
+	return n * mult, nil
+}
+
+// formatSize returns a human readable representation of the size in bytes.
+func formatSize(n int64) string {
+	for _, u := range sizeUnits {
+		if n >= u.size {
+			return fmt.Sprintf("%.1f%sB", float64(n)/float64(u.size), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
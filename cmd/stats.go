@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lucasepe/kvs/internal/store"
+	"github.com/lucasepe/toolbox/flags/commander"
+	"github.com/lucasepe/toolbox/slug"
+)
+
+func newCmdStats() *cmdStats {
+	return &cmdStats{}
+}
+
+type cmdStats struct {
+	bucket  string
+	store   string
+	decrypt bool
+	output  string
+}
+
+func (*cmdStats) Name() string { return "stats" }
+func (*cmdStats) Synopsis() string {
+	return "Show the stored and raw size of the values."
+}
+func (*cmdStats) Usage() string {
+	return strings.ReplaceAll(`{NAME} stats [-s store] [-b bucket] [-d] [-output text|json]
+
+   Show the statistics of all the buckets:
+     {NAME} stats
+
+   Include the raw size of the encrypted values of the 'certs' bucket:
+     {NAME} stats -d -b certs`, "{NAME}", appName)
+}
+
+func (p *cmdStats) SetFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&p.decrypt, "d", false, "decrypt the values to measure their raw size")
+	fs.StringVar(&p.bucket, "b", "", "bucket name (default: all buckets)")
+	outputFlag(fs, &p.output)
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdStats) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	if err := checkOutput(p.output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	if len(p.bucket) > 0 {
+		p.bucket = slug.Slugify(p.bucket)
+	}
+
+	var key []byte
+	if p.decrypt {
+		var err error
+		if key, _, err = secretKey(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return commander.ExitFailure
+		}
+	}
+
+	db, err := openStore(store.Options{
+		BucketName: p.bucket,
+		Path:       p.store,
+		ReadOnly:   true,
+		Key:        key,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+	defer db.Close()
+
+	res, err := db.Stats(p.bucket)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	if p.output == "json" {
+		if res == nil {
+			res = []store.BucketStats{}
+		}
+		if err := printJSON(res); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return commander.ExitFailure
+		}
+		return commander.ExitSuccess
+	}
+
+	var tot store.BucketStats
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "BUCKET\tKEYS\tSIZE\tRAW SIZE\tRATIO\tCOMPRESSED\tENCRYPTED\t")
+	for _, st := range res {
+		printStats(tw, st)
+
+		tot.Keys += st.Keys
+		tot.Size += st.Size
+		tot.RawSize += st.RawSize
+		tot.Compressed += st.Compressed
+		tot.Encrypted += st.Encrypted
+	}
+	if len(res) > 1 {
+		tot.Name = "total"
+		printStats(tw, tot)
+	}
+	tw.Flush()
+
+	if key == nil && tot.Encrypted > 0 {
+		fmt.Fprintln(os.Stderr, "the raw size of the encrypted values is not known, use -d to measure it")
+	}
+
+	return commander.ExitSuccess
+}
+
+func printStats(tw *tabwriter.Writer, st store.BucketStats) {
+	ratio := 1.0
+	if st.RawSize > 0 {
+		ratio = float64(st.Size) / float64(st.RawSize)
+	}
+
+	fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%.0f%%\t%d\t%d\t\n", st.Name, st.Keys,
+		formatSize(st.Size), formatSize(st.RawSize), ratio*100, st.Compressed, st.Encrypted)
+}
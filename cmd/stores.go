@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lucasepe/kvs/internal/store"
+	"github.com/lucasepe/toolbox/flags/commander"
+)
+
+const storesTimeFormat = "2006-01-02 15:04"
+
+func newCmdStores() *cmdGroup {
+	return &cmdGroup{
+		name:     "stores",
+		synopsis: "List or remove the stores of the store directory.",
+		subs: []commander.Command{
+			&cmdStoresList{},
+			&cmdStoresRemove{},
+		},
+		def: "ls",
+	}
+}
+
+type cmdStoresList struct{}
+
+func (*cmdStoresList) Name() string { return "ls" }
+func (*cmdStoresList) Synopsis() string {
+	return "List the stores with their size, buckets and last change."
+}
+func (*cmdStoresList) Usage() string {
+	return strings.ReplaceAll(`{NAME} stores [ls]
+
+   List the stores of the store directory; use their
+   names with the -s flag of the other commands:
+     {NAME} stores
+     {NAME} list -s work`, "{NAME}", appName)
+}
+
+func (*cmdStoresList) SetFlags(fs *flag.FlagSet) {}
+
+func (*cmdStoresList) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	dir, err := storeDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	all, err := filepath.Glob(filepath.Join(dir, "*.kvs"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSIZE\tBUCKETS\tMODIFIED")
+	for _, el := range all {
+		fi, err := os.Stat(el)
+		if err != nil {
+			continue
+		}
+
+		// a store in use by another process is listed anyway
+		buckets := "-"
+		if db, err := store.New(store.Options{Path: el, Outdated: true, ReadOnly: true}); err == nil {
+			buckets = fmt.Sprint(db.BucketCount())
+			db.Close()
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", strings.TrimSuffix(fi.Name(), ".kvs"),
+			formatSize(fi.Size()), buckets, fi.ModTime().Format(storesTimeFormat))
+	}
+	tw.Flush()
+
+	return commander.ExitSuccess
+}
+
+type cmdStoresRemove struct{}
+
+func (*cmdStoresRemove) Name() string { return "rm" }
+func (*cmdStoresRemove) Synopsis() string {
+	return "Delete stores."
+}
+func (*cmdStoresRemove) Usage() string {
+	return strings.ReplaceAll(`{NAME} stores rm <store>...
+
+   Delete the 'work' store:
+     {NAME} stores rm work
+
+   Its backups, if any, are kept.`, "{NAME}", appName)
+}
+
+func (*cmdStoresRemove) SetFlags(fs *flag.FlagSet) {}
+
+func (*cmdStoresRemove) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "store name is required")
+		return commander.ExitFailure
+	}
+
+	for _, el := range fs.Args() {
+		if err := removeStore(el); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return commander.ExitFailure
+		}
+		fmt.Printf("store '%s' successfully deleted\n", el)
+	}
+
+	return commander.ExitSuccess
+}
+
+// removeStore deletes the store file, unless
+// it is in use by another process.
+func removeStore(name string) error {
+	path, err := resolveStore(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("store '%s' not found", name)
+	}
+
+	db, err := newStore(store.Options{Path: path, Outdated: true})
+	if errors.Is(err, store.ErrTooNew) {
+		return os.Remove(path)
+	}
+	if err != nil {
+		return err
+	}
+	db.Close()
+
+	return os.Remove(path)
+}
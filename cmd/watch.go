@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/lucasepe/kvs/internal/store"
+	"github.com/lucasepe/toolbox/flags/commander"
+	"github.com/lucasepe/toolbox/slug"
+)
+
+func newCmdWatch() *cmdWatch {
+	return &cmdWatch{}
+}
+
+type cmdWatch struct {
+	bucket string
+	store  string
+	prefix bool
+	output string
+}
+
+func (*cmdWatch) Name() string { return "watch" }
+func (*cmdWatch) Synopsis() string {
+	return "Print the changes of a bucket as they happen."
+}
+func (*cmdWatch) Usage() string {
+	return strings.ReplaceAll(`{NAME} watch [-s store] [-prefix] [-output text|json] -b bucket [key]
+
+   Print the changes of the keys of the 'myapp' bucket:
+     {NAME} watch -b myapp
+
+   Print the changes of the key 'db.host' as JSON lines:
+     {NAME} watch -output json -b myapp db.host
+
+   Print the changes of the keys starting with 'db.':
+     {NAME} watch -prefix -b myapp db.
+
+   Each change is printed as revision, operation (set or delete),
+   bucket and key; the changes made by other processes are included.`, "{NAME}", appName)
+}
+
+func (p *cmdWatch) SetFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&p.prefix, "prefix", false, "watch the keys starting with key")
+	outputFlag(fs, &p.output)
+	bucketFlag(fs, &p.bucket)
+	storeFlag(fs, &p.store)
+}
+
+func (p *cmdWatch) Execute(fs *flag.FlagSet) commander.ExitStatus {
+	if err := p.watch(fs); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return commander.ExitFailure
+	}
+
+	return commander.ExitSuccess
+}
+
+func (p *cmdWatch) watch(fs *flag.FlagSet) error {
+	if err := checkOutput(p.output); err != nil {
+		return err
+	}
+	if len(p.bucket) == 0 {
+		return fmt.Errorf("bucket name is required")
+	}
+	p.bucket = slug.Slugify(p.bucket)
+
+	key := fs.Arg(0)
+	if p.prefix && len(key) == 0 {
+		return fmt.Errorf("-prefix requires the key")
+	}
+
+	db, err := openStore(store.Options{
+		Path:     p.store,
+		ReadOnly: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	enc := json.NewEncoder(os.Stdout)
+	for ev := range db.Watch(ctx, p.bucket, key) {
+		if !p.prefix && len(key) > 0 && ev.Key != key {
+			continue
+		}
+
+		if p.output == "json" {
+			err = enc.Encode(ev)
+		} else {
+			_, err = fmt.Printf("%d\t%s\t%s\t%s\n", ev.Revision, ev.Op, ev.Bucket, ev.Key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,328 @@
+package aes
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// The streaming format splits the plaintext in segments of SegmentSize
+// bytes, each one sealed with AES-GCM (STREAM construction):
+//
+//	header:  0x00 'K' 'V' 'E' | version (1 byte) | salt (16 bytes) | nonce prefix (7 bytes)
+//	segment: ciphertext | tag (16 bytes)
+//
+// Every stream is sealed with its own key, derived from the key and the
+// random salt with HKDF-SHA256 (as the AES-GCM-HKDF streaming of Tink),
+// and the header is the additional data of every segment.
+//
+// The nonce of every segment is the nonce prefix followed by the segment
+// counter (4 bytes, big endian) and by a flag (1 byte) set to 1 only for
+// the last segment, so segments cannot be reordered, dropped or truncated
+// without being detected. Only the last segment can be shorter than
+// SegmentSize (and it can be empty).
+//
+// The streams of version 1 have no salt and are sealed with the key
+// itself, without additional data: they are still read.
+
+const (
+	// SegmentSize is the size of a plaintext segment.
+	SegmentSize = 64 * 1024
+	// StreamHeaderSize is the size of the stream header,
+	// larger than the one of version 1.
+	StreamHeaderSize = 28
+
+	streamVersion = 2
+	saltSize      = 16
+	prefixSize    = 7
+	tagSize       = 16
+
+	legacyVersion    = 1
+	legacyHeaderSize = 12
+)
+
+var streamMagic = []byte{0x00, 'K', 'V', 'E'}
+
+var (
+	// ErrInvalidStream is returned when the data is not in the streaming format.
+	ErrInvalidStream = errors.New("aes: invalid stream header")
+	// ErrTruncatedStream is returned when the last segment is missing.
+	ErrTruncatedStream = errors.New("aes: truncated stream")
+	// ErrStreamTooLarge is returned when the segment counter overflows.
+	ErrStreamTooLarge = errors.New("aes: stream too large")
+)
+
+// IsStream reports whether the data starts with the streaming format header.
+func IsStream(dat []byte) bool {
+	if len(dat) <= len(streamMagic) || !bytes.HasPrefix(dat, streamMagic) {
+		return false
+	}
+	size := headerSize(dat[len(streamMagic)])
+	return size > 0 && len(dat) >= size
+}
+
+// headerSize returns the size of the header of the version, 0 if unknown.
+func headerSize(version byte) int {
+	switch version {
+	case streamVersion:
+		return StreamHeaderSize
+	case legacyVersion:
+		return legacyHeaderSize
+	default:
+		return 0
+	}
+}
+
+// streamKey derives the key of the stream from the key and the salt.
+func streamKey(key, salt []byte) ([]byte, error) {
+	res := make([]byte, len(key))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte("kvs stream")), res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+type segmenter struct {
+	aead   cipher.AEAD
+	nonce  []byte
+	aad    []byte
+	closed bool
+	count  uint64
+}
+
+func newSegmenter(key, prefix, aad []byte) (*segmenter, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	copy(nonce, prefix)
+
+	return &segmenter{aead: gcm, nonce: nonce, aad: aad}, nil
+}
+
+// next updates the nonce for the next segment.
+func (s *segmenter) next(last bool) error {
+	if s.count > math.MaxUint32 {
+		return ErrStreamTooLarge
+	}
+
+	binary.BigEndian.PutUint32(s.nonce[prefixSize:], uint32(s.count))
+	s.nonce[len(s.nonce)-1] = 0
+	if last {
+		s.nonce[len(s.nonce)-1] = 1
+	}
+	s.count++
+
+	return nil
+}
+
+// NewWriter returns a writer that encrypts using AES-GCM in the streaming
+// format and writes the result to w, using a constant amount of memory.
+// Close must be called to write the last segment; it does not close w.
+func NewWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	header := make([]byte, StreamHeaderSize)
+	copy(header, streamMagic)
+	header[len(streamMagic)] = streamVersion
+	salt, prefix := header[len(streamMagic)+1:][:saltSize], header[StreamHeaderSize-prefixSize:]
+	if _, err := io.ReadFull(rand.Reader, header[len(streamMagic)+1:]); err != nil {
+		return nil, err
+	}
+
+	sk, err := streamKey(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	seg, err := newSegmenter(sk, prefix, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &streamWriter{
+		w:   w,
+		seg: seg,
+		buf: make([]byte, 0, SegmentSize),
+		out: make([]byte, 0, SegmentSize+tagSize),
+	}, nil
+}
+
+type streamWriter struct {
+	w   io.Writer
+	seg *segmenter
+	buf []byte
+	out []byte
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if sw.seg.closed {
+		return 0, errors.New("aes: write on closed stream")
+	}
+
+	var n int
+	for len(p) > 0 {
+		// a full segment is sealed only when more data arrives,
+		// since the last one must be flagged as such.
+		if len(sw.buf) == SegmentSize {
+			if err := sw.flush(false); err != nil {
+				return n, err
+			}
+		}
+
+		l := copy(sw.buf[len(sw.buf):SegmentSize], p)
+		sw.buf = sw.buf[:len(sw.buf)+l]
+		p = p[l:]
+		n += l
+	}
+
+	return n, nil
+}
+
+// Close writes the last segment.
+func (sw *streamWriter) Close() error {
+	if sw.seg.closed {
+		return nil
+	}
+
+	err := sw.flush(true)
+	sw.seg.closed = true
+	return err
+}
+
+func (sw *streamWriter) flush(last bool) error {
+	if err := sw.seg.next(last); err != nil {
+		return err
+	}
+
+	sw.out = sw.seg.aead.Seal(sw.out[:0], sw.seg.nonce, sw.buf, sw.seg.aad)
+	sw.buf = sw.buf[:0]
+
+	_, err := sw.w.Write(sw.out)
+	return err
+}
+
+// NewReader returns a reader that decrypts the data in streaming format
+// read from r, authenticating one segment at a time.
+func NewReader(r io.Reader, key []byte) (io.Reader, error) {
+	header := make([]byte, StreamHeaderSize)
+	n := len(streamMagic) + 1
+	if _, err := io.ReadFull(r, header[:n]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrInvalidStream
+		}
+		return nil, err
+	}
+	if !bytes.HasPrefix(header, streamMagic) {
+		return nil, ErrInvalidStream
+	}
+	size := headerSize(header[n-1])
+	if size == 0 {
+		return nil, ErrInvalidStream
+	}
+
+	header = header[:size]
+	if _, err := io.ReadFull(r, header[n:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrInvalidStream
+		}
+		return nil, err
+	}
+
+	var seg *segmenter
+	var err error
+	if size == legacyHeaderSize {
+		seg, err = newSegmenter(key, header[n:], nil)
+	} else {
+		var sk []byte
+		if sk, err = streamKey(key, header[n:n+saltSize]); err == nil {
+			seg, err = newSegmenter(sk, header[n+saltSize:], header)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &streamReader{
+		r:   bufio.NewReaderSize(r, SegmentSize+tagSize),
+		seg: seg,
+		in:  make([]byte, SegmentSize+tagSize),
+	}, nil
+}
+
+type streamReader struct {
+	r   *bufio.Reader
+	seg *segmenter
+	in  []byte
+	out []byte
+	err error
+}
+
+func (sr *streamReader) Read(p []byte) (int, error) {
+	for len(sr.out) == 0 {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		if sr.seg.closed {
+			return 0, io.EOF
+		}
+		sr.err = sr.open()
+	}
+
+	n := copy(p, sr.out)
+	sr.out = sr.out[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next segment.
+func (sr *streamReader) open() error {
+	n, err := io.ReadFull(sr.r, sr.in)
+	switch {
+	case err == io.EOF:
+		return ErrTruncatedStream
+	case err == io.ErrUnexpectedEOF:
+		// a short segment is always the last one
+	case err != nil:
+		return err
+	}
+
+	last := n < len(sr.in)
+	if !last {
+		if _, err := sr.r.Peek(1); err == io.EOF {
+			last = true
+		}
+	}
+
+	if n < tagSize {
+		return ErrTruncatedStream
+	}
+
+	if err := sr.seg.next(last); err != nil {
+		return err
+	}
+
+	sr.out, err = sr.seg.aead.Open(sr.in[:0], sr.seg.nonce, sr.in[:n], sr.seg.aad)
+	if err != nil {
+		return err
+	}
+
+	sr.seg.closed = last
+	return nil
+}
@@ -0,0 +1,159 @@
+package aes
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/lucasepe/kvs/internal/pbdk"
+)
+
+func encryptStream(t *testing.T, src, key []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(src); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func decryptStream(src, key []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(src), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStream(t *testing.T) {
+	key, err := pbdk.DeriveKey([]byte("abbracadabbra!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := []int{0, 1, SegmentSize - 1, SegmentSize, SegmentSize + 1, 3*SegmentSize + 17}
+
+	for _, size := range sizes {
+		src := make([]byte, size)
+		if _, err := rand.Read(src); err != nil {
+			t.Fatal(err)
+		}
+
+		enc := encryptStream(t, src, key)
+		if !IsStream(enc) {
+			t.Fatalf("size %d: missing stream header", size)
+		}
+
+		dec, err := decryptStream(enc, key)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(src, dec) {
+			t.Fatalf("size %d: decrypted data does not match", size)
+		}
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	key, err := pbdk.DeriveKey([]byte("s1mSal5Bim$$"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := make([]byte, 2*SegmentSize+100)
+	enc := encryptStream(t, src, key)
+	segment := SegmentSize + tagSize
+
+	tests := map[string][]byte{
+		// the last segment has been dropped
+		"truncated": enc[:StreamHeaderSize+2*segment],
+		// a byte of the first segment has been modified
+		"modified": func() []byte {
+			res := append([]byte{}, enc...)
+			res[StreamHeaderSize+10] ^= 0xff
+			return res
+		}(),
+		// the first two segments have been swapped
+		"reordered": func() []byte {
+			res := append([]byte{}, enc[:StreamHeaderSize]...)
+			res = append(res, enc[StreamHeaderSize+segment:StreamHeaderSize+2*segment]...)
+			res = append(res, enc[StreamHeaderSize:StreamHeaderSize+segment]...)
+			return append(res, enc[StreamHeaderSize+2*segment:]...)
+		}(),
+		"no header": enc[StreamHeaderSize:],
+		// the header is authenticated
+		"modified salt": func() []byte {
+			res := append([]byte{}, enc...)
+			res[len(streamMagic)+1] ^= 0xff
+			return res
+		}(),
+		"modified prefix": func() []byte {
+			res := append([]byte{}, enc...)
+			res[StreamHeaderSize-1] ^= 0xff
+			return res
+		}(),
+		"downgraded": func() []byte {
+			res := append([]byte{}, enc...)
+			res[len(streamMagic)] = legacyVersion
+			return res
+		}(),
+	}
+
+	for name, tc := range tests {
+		if _, err := decryptStream(tc, key); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+
+	other, _ := pbdk.DeriveKey([]byte("maGIKaBul444="))
+	if _, err := decryptStream(enc, other); err == nil {
+		t.Fatal("wrong key: expected an error")
+	}
+}
+
+func TestStreamLegacy(t *testing.T) {
+	key, err := pbdk.DeriveKey([]byte("abbracadabbra!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// version 1: no salt, sealed with the key itself
+	prefix := bytes.Repeat([]byte{0x07}, prefixSize)
+	seg, err := newSegmenter(key, prefix, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := make([]byte, SegmentSize+10)
+	enc := append(append(append([]byte{}, streamMagic...), legacyVersion), prefix...)
+	for i, last := range []bool{false, true} {
+		if err := seg.next(last); err != nil {
+			t.Fatal(err)
+		}
+		part := src[:SegmentSize]
+		if i == 1 {
+			part = src[SegmentSize:]
+		}
+		enc = seg.aead.Seal(enc, seg.nonce, part, nil)
+	}
+
+	if !IsStream(enc) {
+		t.Fatal("missing stream header")
+	}
+	dec, err := decryptStream(enc, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, dec) {
+		t.Fatal("decrypted data does not match")
+	}
+}
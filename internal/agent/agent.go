@@ -0,0 +1,221 @@
+// Package agent keeps the key derived from the secret phrase in memory,
+// like ssh-agent does, and serves it to the kvs commands over a unix socket.
+//
+// The protocol is line oriented: each connection carries a single
+// request line, a command with an optional argument, and a single
+// reply line, "OK" followed by the optional result or "ERR" followed
+// by the error message.
+//
+//	KEY            returns the hex encoded key
+//	UNLOCK <hex>   stores the key
+//	LOCK           wipes the key
+package agent
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrLocked is returned when the agent does not hold the key
+	ErrLocked = errors.New("kvs: the agent is locked")
+)
+
+// Agent holds the key in locked memory, wiping it
+// when it has not been requested for the idle timeout.
+type Agent struct {
+	timeout time.Duration
+
+	mu    sync.Mutex
+	key   []byte
+	timer *time.Timer
+}
+
+// New creates a locked agent; a zero timeout never wipes the key.
+func New(timeout time.Duration) *Agent {
+	return &Agent{timeout: timeout}
+}
+
+// Unlock stores a copy of the key.
+func (a *Agent) Unlock(key []byte) error {
+	buf, err := lockedAlloc(len(key))
+	if err != nil {
+		return err
+	}
+	copy(buf, key)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.wipe()
+	a.key = buf
+	a.touch()
+	return nil
+}
+
+// Lock wipes the key.
+func (a *Agent) Lock() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.wipe()
+}
+
+// Key returns a copy of the key, restarting the idle timeout.
+func (a *Agent) Key() ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.key == nil {
+		return nil, ErrLocked
+	}
+	a.touch()
+
+	return append([]byte{}, a.key...), nil
+}
+
+// touch restarts the idle timeout; a.mu must be held.
+func (a *Agent) touch() {
+	if a.timeout <= 0 {
+		return
+	}
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	a.timer = time.AfterFunc(a.timeout, a.Lock)
+}
+
+// wipe zeroes and releases the key; a.mu must be held.
+func (a *Agent) wipe() {
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	if a.key != nil {
+		lockedFree(a.key)
+		a.key = nil
+	}
+}
+
+// Serve answers the requests accepted on l until it is closed.
+func (a *Agent) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go a.handle(conn)
+	}
+}
+
+func (a *Agent) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+
+	res, err := a.exec(strings.TrimSpace(line))
+	if err != nil {
+		fmt.Fprintf(conn, "ERR %s\n", err)
+		return
+	}
+	fmt.Fprintf(conn, "OK %s\n", res)
+}
+
+func (a *Agent) exec(req string) (string, error) {
+	cmd, arg, _ := strings.Cut(req, " ")
+	switch cmd {
+	case "KEY":
+		key, err := a.Key()
+		if err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(key), nil
+	case "UNLOCK":
+		key, err := hex.DecodeString(arg)
+		if err != nil || len(key) == 0 {
+			return "", fmt.Errorf("invalid key")
+		}
+		return "", a.Unlock(key)
+	case "LOCK":
+		a.Lock()
+		return "", nil
+	default:
+		return "", fmt.Errorf("unknown command: %s", cmd)
+	}
+}
+
+// Listen creates the unix socket at path, readable and writable
+// only by the current user, replacing a stale one. The directory of
+// the socket is created if missing and must belong to the current
+// user, with mode 0700.
+func Listen(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	if err := checkDir(dir); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another process is already listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := listenUnix(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// checkDir refuses the directory of the socket unless it is a real
+// directory, owned by the current user and private: in a shared one,
+// like the temporary directory, another user could create it first.
+func checkDir(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() || !owned(fi) || fi.Mode().Perm() != 0700 {
+		return fmt.Errorf("insecure socket directory %s: it must be owned by the current user, with mode 0700", dir)
+	}
+	return nil
+}
+
+// DefaultSocket returns the default path of the agent socket,
+// in the user runtime directory if available.
+func DefaultSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "kvs", "agent.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("kvs-%d", os.Getuid()), "agent.sock")
+}
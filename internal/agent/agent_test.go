@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAgent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kvs", "agent.sock")
+
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go New(100 * time.Millisecond).Serve(l)
+
+	c := NewClient(path)
+	if _, err := c.Key(); err != ErrLocked {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+
+	key := bytes.Repeat([]byte{0x42}, 32)
+	if err := c.Unlock(key); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Fatalf("expected %x, got %x", key, got)
+	}
+
+	if err := c.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Key(); err != ErrLocked {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+
+	if err := c.Unlock(key); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if _, err := c.Key(); err != ErrLocked {
+		t.Fatalf("expected the key to expire, got %v", err)
+	}
+
+	if _, err := Listen(path); err == nil {
+		t.Fatal("expected an error listening twice on the same socket")
+	}
+}
+
+func TestListenInsecureDir(t *testing.T) {
+	dir := t.TempDir()
+
+	shared := filepath.Join(dir, "shared")
+	if err := os.Mkdir(shared, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(shared, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(filepath.Join(shared, "agent.sock")); err == nil {
+		t.Fatal("expected a directory accessible by others to be refused")
+	}
+
+	private := filepath.Join(dir, "private")
+	if err := os.Mkdir(private, 0700); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(private, link); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(filepath.Join(link, "agent.sock")); err == nil {
+		t.Fatal("expected a symbolic link to be refused")
+	}
+
+	path := filepath.Join(dir, "kvs", "agent.sock")
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Fatalf("expected the socket mode to be 0600, got %o", perm)
+	}
+}
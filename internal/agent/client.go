@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Client talks to the agent listening on a unix socket.
+type Client struct {
+	path string
+}
+
+// NewClient creates a client of the agent listening at path.
+func NewClient(path string) *Client {
+	return &Client{path: path}
+}
+
+// Key returns the key held by the agent, ErrLocked if it has none.
+func (c *Client) Key() ([]byte, error) {
+	res, err := c.request("KEY")
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(res)
+}
+
+// Unlock hands the key to the agent.
+func (c *Client) Unlock(key []byte) error {
+	_, err := c.request("UNLOCK " + hex.EncodeToString(key))
+	return err
+}
+
+// Lock asks the agent to wipe the key.
+func (c *Client) Lock() error {
+	_, err := c.request("LOCK")
+	return err
+}
+
+func (c *Client) request(req string) (string, error) {
+	conn, err := net.DialTimeout("unix", c.path, time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := fmt.Fprintf(conn, "%s\n", req); err != nil {
+		return "", err
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	status, res, _ := strings.Cut(strings.TrimSpace(line), " ")
+	switch status {
+	case "OK":
+		return res, nil
+	case "ERR":
+		if res == ErrLocked.Error() {
+			return "", ErrLocked
+		}
+		return "", errors.New(res)
+	default:
+		return "", fmt.Errorf("kvs: unexpected agent reply: %s", line)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package agent
+
+// lockedAlloc returns a buffer of n bytes; memory
+// locking is not supported on this platform.
+func lockedAlloc(n int) ([]byte, error) {
+	return make([]byte, n), nil
+}
+
+// lockedFree zeroes a buffer returned by lockedAlloc.
+func lockedFree(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+}
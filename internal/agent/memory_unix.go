@@ -0,0 +1,29 @@
+//go:build unix
+
+package agent
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// lockedAlloc returns a buffer of n bytes that is never swapped to disk.
+func lockedAlloc(n int) ([]byte, error) {
+	buf, err := unix.Mmap(-1, 0, n, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Mlock(buf); err != nil {
+		unix.Munmap(buf)
+		return nil, err
+	}
+	return buf, nil
+}
+
+// lockedFree zeroes and releases a buffer returned by lockedAlloc.
+func lockedFree(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+	unix.Munlock(buf)
+	unix.Munmap(buf)
+}
@@ -0,0 +1,19 @@
+//go:build !unix
+
+package agent
+
+import (
+	"net"
+	"os"
+)
+
+// owned reports whether the file is owned by the current user;
+// ownership is not checked on this platform.
+func owned(fi os.FileInfo) bool {
+	return true
+}
+
+// listenUnix creates the unix socket at path.
+func listenUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package agent
+
+import (
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// owned reports whether the file is owned by the current user.
+func owned(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && int(st.Uid) == os.Getuid()
+}
+
+// listenUnix creates the unix socket at path with no permissions
+// for the group and the others, from the very beginning.
+func listenUnix(path string) (net.Listener, error) {
+	old := unix.Umask(0077)
+	defer unix.Umask(old)
+
+	return net.Listen("unix", path)
+}
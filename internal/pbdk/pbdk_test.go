@@ -1,6 +1,7 @@
 package pbdk
 
 import (
+	"bytes"
 	"encoding/base64"
 	"testing"
 )
@@ -54,3 +55,24 @@ func TestDeriveKey(t *testing.T) {
 		}
 	}
 }
+
+func TestDeriveKeyN(t *testing.T) {
+	secret := []byte("abbracadabbra!")
+
+	def, _ := DeriveKey(secret)
+	got, err := DeriveKeyN(secret, DefaultIterations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, def) {
+		t.Fatal("expected the same key with the default iterations")
+	}
+
+	got, err = DeriveKeyN(secret, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got, def) {
+		t.Fatal("expected a different key with more iterations")
+	}
+}
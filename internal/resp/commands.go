@@ -0,0 +1,551 @@
+package resp
+
+import (
+	"crypto/subtle"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lucasepe/kvs/internal/store"
+)
+
+func (s *Server) auth(c *conn, args [][]byte) {
+	if len(args) == 0 || len(args) > 2 {
+		c.w.error("ERR wrong number of arguments for 'auth' command")
+		return
+	}
+	if len(s.token) == 0 {
+		c.w.error("ERR AUTH called without any password configured")
+		return
+	}
+
+	// AUTH [username] password
+	pass := args[len(args)-1]
+	if subtle.ConstantTimeCompare(pass, []byte(s.token)) != 1 {
+		c.authed = false
+		c.w.error("WRONGPASS invalid username-password pair or user is disabled.")
+		return
+	}
+	c.authed = true
+	c.w.simple("OK")
+}
+
+func (s *Server) ping(c *conn, args [][]byte) {
+	if len(args) > 0 {
+		c.w.bulk(args[0])
+		return
+	}
+	c.w.simple("PONG")
+}
+
+func (s *Server) selectBucket(c *conn, args [][]byte) {
+	if !validBucket(args[0]) {
+		c.w.error(errBucket)
+		return
+	}
+	c.bucket = string(args[0])
+	c.w.simple("OK")
+}
+
+// validBucket reports whether the name can be used as a bucket:
+// the reserved ones hold the internals of the store.
+func validBucket(name []byte) bool {
+	return len(name) > 0 && !store.IsReserved(string(name))
+}
+
+func (s *Server) dbsize(c *conn, args [][]byte) {
+	c.w.integer(int64(len(s.db.InBucket(c.bucket).Keys())))
+}
+
+func (s *Server) get(c *conn, args [][]byte) {
+	v, kind, err := s.value(c.bucket, args[0])
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if v != nil && kind != store.KindBlob {
+		c.w.error(errWrongType)
+		return
+	}
+	c.w.bulk(v)
+}
+
+// set handles SET key value [EX seconds | PX milliseconds | KEEPTTL] [NX | XX].
+func (s *Server) set(c *conn, args [][]byte) {
+	var ttl time.Duration
+	var nx, xx, keepTTL bool
+
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i])); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX":
+			if i+1 == len(args) {
+				c.w.error(errSyntax)
+				return
+			}
+			i++
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil || n <= 0 {
+				c.w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+			ttl = time.Duration(n) * time.Second
+			if opt == "PX" {
+				ttl = time.Duration(n) * time.Millisecond
+			}
+		default:
+			c.w.error(errSyntax)
+			return
+		}
+	}
+	if (nx && xx) || (ttl > 0 && keepTTL) {
+		c.w.error(errSyntax)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	db := s.db.InBucket(c.bucket)
+	key := string(args[0])
+
+	if nx || xx {
+		ok, err := s.exists(c.bucket, args[0])
+		if err != nil {
+			fail(c, err)
+			return
+		}
+		if ok == nx {
+			c.w.bulk(nil)
+			return
+		}
+	}
+
+	err := db.Set(key, args[1])
+	if err == nil && ttl > 0 {
+		err = db.Expire(key, time.Now().Add(ttl))
+	} else if err == nil && !keepTTL {
+		err = db.Expire(key, time.Time{})
+	}
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.w.simple("OK")
+}
+
+func (s *Server) del(c *conn, args [][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteKeys(c, c.bucket, args)
+}
+
+func (s *Server) exists(bucket string, key []byte) (bool, error) {
+	_, err := s.db.InBucket(bucket).Stat(string(key))
+	if errors.Is(err, store.ErrKeyNotFound) || errors.Is(err, store.ErrBucketNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *Server) existsCmd(c *conn, args [][]byte) {
+	var n int64
+	for _, el := range args {
+		ok, err := s.exists(c.bucket, el)
+		if err != nil {
+			fail(c, err)
+			return
+		}
+		if ok {
+			n++
+		}
+	}
+	c.w.integer(n)
+}
+
+func (s *Server) typeOf(c *conn, args [][]byte) {
+	info, err := s.db.InBucket(c.bucket).Stat(string(args[0]))
+	if errors.Is(err, store.ErrKeyNotFound) || errors.Is(err, store.ErrBucketNotFound) {
+		c.w.simple("none")
+		return
+	}
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	switch info.Kind {
+	case store.KindList:
+		c.w.simple("list")
+	case store.KindSet:
+		c.w.simple("set")
+	default:
+		c.w.simple("string")
+	}
+}
+
+func (s *Server) keys(c *conn, args [][]byte) {
+	var res []string
+	for _, el := range s.db.InBucket(c.bucket).Keys() {
+		if match(string(args[0]), el) {
+			res = append(res, el)
+		}
+	}
+	c.w.strings(res)
+}
+
+// scan handles SCAN cursor [MATCH pattern] [COUNT count]:
+// the cursor is the position in the sorted keys.
+func (s *Server) scan(c *conn, args [][]byte) {
+	cursor, err := strconv.Atoi(string(args[0]))
+	if err != nil || cursor < 0 {
+		c.w.error("ERR invalid cursor")
+		return
+	}
+
+	pattern, count := "*", 10
+	for i := 1; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			c.w.error(errSyntax)
+			return
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = string(args[i+1])
+		case "COUNT":
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil || count < 1 {
+				c.w.error(errSyntax)
+				return
+			}
+		default:
+			c.w.error(errSyntax)
+			return
+		}
+	}
+
+	all := s.db.InBucket(c.bucket).Keys()
+	sort.Strings(all)
+
+	var res []string
+	next := cursor
+	for ; next < len(all) && next < cursor+count; next++ {
+		if match(pattern, all[next]) {
+			res = append(res, all[next])
+		}
+	}
+	if next >= len(all) {
+		next = 0
+	}
+
+	c.w.array(2)
+	c.w.bulk([]byte(strconv.Itoa(next)))
+	c.w.strings(res)
+}
+
+func (s *Server) incrBy(c *conn, args [][]byte, sign int64) {
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		c.w.error(errNotInteger)
+		return
+	}
+	s.incr(c, args[0], sign*n)
+}
+
+func (s *Server) incr(c *conn, key []byte, by int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, kind, err := s.value(c.bucket, key)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if v != nil && kind != store.KindBlob {
+		c.w.error(errWrongType)
+		return
+	}
+
+	var n int64
+	if v != nil {
+		if n, err = strconv.ParseInt(string(v), 10, 64); err != nil {
+			c.w.error(errNotInteger)
+			return
+		}
+	}
+	if (by > 0 && n > n+by) || (by < 0 && n < n+by) {
+		c.w.error("ERR increment or decrement would overflow")
+		return
+	}
+	n += by
+
+	if err := s.db.InBucket(c.bucket).Set(string(key), []byte(strconv.FormatInt(n, 10))); err != nil {
+		fail(c, err)
+		return
+	}
+	c.w.integer(n)
+}
+
+func (s *Server) expire(c *conn, args [][]byte, unit time.Duration) {
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		c.w.error(errNotInteger)
+		return
+	}
+
+	err = s.db.InBucket(c.bucket).Expire(string(args[0]), time.Now().Add(time.Duration(n)*unit))
+	if errors.Is(err, store.ErrKeyNotFound) || errors.Is(err, store.ErrBucketNotFound) {
+		c.w.integer(0)
+		return
+	}
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.w.integer(1)
+}
+
+// ttl replies -2 if the key does not exist, -1 if it does not expire.
+func (s *Server) ttl(c *conn, args [][]byte, unit time.Duration) {
+	at, err := s.db.InBucket(c.bucket).ExpiresAt(string(args[0]))
+	if errors.Is(err, store.ErrKeyNotFound) || errors.Is(err, store.ErrBucketNotFound) {
+		c.w.integer(-2)
+		return
+	}
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if at.IsZero() {
+		c.w.integer(-1)
+		return
+	}
+
+	d := time.Until(at)
+	if d < 0 {
+		d = 0
+	}
+	c.w.integer(int64((d + unit/2) / unit))
+}
+
+func (s *Server) persist(c *conn, args [][]byte) {
+	db := s.db.InBucket(c.bucket)
+
+	at, err := db.ExpiresAt(string(args[0]))
+	if errors.Is(err, store.ErrKeyNotFound) || errors.Is(err, store.ErrBucketNotFound) || (err == nil && at.IsZero()) {
+		c.w.integer(0)
+		return
+	}
+	if err == nil {
+		err = db.Expire(string(args[0]), time.Time{})
+	}
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.w.integer(1)
+}
+
+func (s *Server) hget(c *conn, args [][]byte) {
+	v, _, err := s.value(string(args[0]), args[1])
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.w.bulk(v)
+}
+
+// hset handles HSET hash field value [field value ...],
+// replying with the number of new fields.
+func (s *Server) hset(c *conn, args [][]byte) {
+	if len(args)%2 == 0 {
+		c.w.error("ERR wrong number of arguments for 'hset' command")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := string(args[0])
+	db := s.db.InBucket(hash)
+
+	var n int64
+	for i := 1; i < len(args); i += 2 {
+		ok, err := s.exists(hash, args[i])
+		if err == nil {
+			err = db.Set(string(args[i]), args[i+1])
+		}
+		if err != nil {
+			fail(c, err)
+			return
+		}
+		if !ok {
+			n++
+		}
+	}
+	c.w.integer(n)
+}
+
+func (s *Server) hdel(c *conn, args [][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteKeys(c, string(args[0]), args[1:])
+}
+
+func (s *Server) hexists(c *conn, args [][]byte) {
+	ok, err := s.exists(string(args[0]), args[1])
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if ok {
+		c.w.integer(1)
+		return
+	}
+	c.w.integer(0)
+}
+
+func (s *Server) hlen(c *conn, args [][]byte) {
+	c.w.integer(int64(len(s.db.InBucket(string(args[0])).Keys())))
+}
+
+func (s *Server) hkeys(c *conn, args [][]byte) {
+	c.w.strings(s.db.InBucket(string(args[0])).Keys())
+}
+
+func (s *Server) hgetall(c *conn, args [][]byte) {
+	hash := string(args[0])
+	fields := s.db.InBucket(hash).Keys()
+
+	c.w.array(2 * len(fields))
+	for _, el := range fields {
+		v, _, err := s.value(hash, []byte(el))
+		if err != nil {
+			v = nil
+		}
+		c.w.bulk([]byte(el))
+		c.w.bulk(v)
+	}
+}
+
+// deleteKeys deletes the keys of the bucket,
+// replying with the number of deleted ones.
+func (s *Server) deleteKeys(c *conn, bucket string, keys [][]byte) {
+	db := s.db.InBucket(bucket)
+
+	var n int64
+	for _, el := range keys {
+		ok, err := s.exists(bucket, el)
+		if err == nil && ok {
+			err = db.Delete(string(el))
+		}
+		if err != nil {
+			fail(c, err)
+			return
+		}
+		if ok {
+			n++
+		}
+	}
+	c.w.integer(n)
+}
+
+// value returns the value of the key and its kind, nil if it does not exist.
+func (s *Server) value(bucket string, key []byte) ([]byte, store.Kind, error) {
+	db := s.db.InBucket(bucket)
+	info, err := db.Stat(string(key))
+	if errors.Is(err, store.ErrBucketNotFound) || errors.Is(err, store.ErrKeyNotFound) {
+		return nil, store.KindBlob, nil
+	}
+	if err != nil {
+		return nil, store.KindBlob, err
+	}
+
+	v, err := db.Get(string(key))
+	return v, info.Kind, err
+}
+
+func fail(c *conn, err error) {
+	c.w.error("ERR " + strings.TrimPrefix(err.Error(), "kvs: "))
+}
+
+// match reports whether s matches the glob-style pattern of KEYS:
+// '*' matches any sequence, '?' any character, '[...]' a set
+// (with ranges and '^' for negation) and '\' escapes.
+func match(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if match(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				return pattern == s
+			}
+			if !matchSet(pattern[1:end+1], s[0]) {
+				return false
+			}
+			pattern = pattern[end+1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+func matchSet(set string, ch byte) bool {
+	negate := strings.HasPrefix(set, "^")
+	if negate {
+		set = set[1:]
+	}
+
+	found := false
+	for i := 0; i < len(set); i++ {
+		if i+2 < len(set) && set[i+1] == '-' {
+			if set[i] <= ch && ch <= set[i+2] {
+				found = true
+			}
+			i += 2
+			continue
+		}
+		if set[i] == ch {
+			found = true
+		}
+	}
+	return found != negate
+}
@@ -0,0 +1,133 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// limits are the maximum number of arguments of a command
+// and size of each of them.
+type limits struct {
+	args int
+	bulk int
+}
+
+var (
+	// authLimits apply until the client has authenticated:
+	// enough for AUTH, but nothing large is allocated.
+	authLimits = limits{args: 8, bulk: 4096}
+	// cmdLimits apply to the authenticated clients.
+	cmdLimits = limits{args: 1024 * 1024, bulk: 512 << 20}
+)
+
+var errProtocol = errors.New("Protocol error")
+
+// readCommand reads a command as an array of bulk strings or,
+// as sent by telnet, as an inline line of words.
+func readCommand(br *bufio.Reader, lim limits) ([][]byte, error) {
+	line, err := readLine(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+
+	if line[0] != '*' {
+		return bytes.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > lim.args {
+		return nil, errProtocol
+	}
+
+	// the arguments are allocated as they arrive
+	args := make([][]byte, 0, min(n, 64))
+	for i := 0; i < n; i++ {
+		line, err := readLine(br)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errProtocol
+		}
+
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > lim.bulk {
+			return nil, errProtocol
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(buf, []byte("\r\n")) {
+			return nil, errProtocol
+		}
+		args = append(args, buf[:size])
+	}
+
+	return args, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func readLine(br *bufio.Reader) ([]byte, error) {
+	line, err := br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, errProtocol
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+// writer writes the replies.
+type writer struct {
+	*bufio.Writer
+}
+
+func (w writer) simple(s string) {
+	fmt.Fprintf(w, "+%s\r\n", s)
+}
+
+func (w writer) error(s string) {
+	fmt.Fprintf(w, "-%s\r\n", s)
+}
+
+func (w writer) integer(n int64) {
+	fmt.Fprintf(w, ":%d\r\n", n)
+}
+
+// bulk writes v, or the null bulk string if v is nil.
+func (w writer) bulk(v []byte) {
+	if v == nil {
+		w.WriteString("$-1\r\n")
+		return
+	}
+	fmt.Fprintf(w, "$%d\r\n", len(v))
+	w.Write(v)
+	w.WriteString("\r\n")
+}
+
+func (w writer) array(n int) {
+	fmt.Fprintf(w, "*%d\r\n", n)
+}
+
+func (w writer) strings(list []string) {
+	w.array(len(list))
+	for _, el := range list {
+		w.bulk([]byte(el))
+	}
+}
@@ -0,0 +1,236 @@
+// Package resp serves a store to Redis clients, speaking
+// the Redis serialization protocol (RESP2).
+//
+// The keys live in the bucket chosen by SELECT (by default '0'),
+// while the hash commands use the hash name as bucket and its
+// fields as keys. Expirations are kept in the store.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucasepe/kvs/internal/store"
+)
+
+// DefaultBucket is the bucket selected by a new connection.
+const DefaultBucket = "0"
+
+// purgeInterval is how often the expired keys are deleted.
+const purgeInterval = time.Minute
+
+const (
+	errWrongType  = "WRONGTYPE Operation against a key holding the wrong kind of value"
+	errNotInteger = "ERR value is not an integer or out of range"
+	errSyntax     = "ERR syntax error"
+	errBucket     = "ERR invalid bucket name"
+)
+
+// Server serves the store to Redis clients.
+type Server struct {
+	db    *store.Store
+	token string
+
+	// mu makes the commands that read and then write atomic
+	mu sync.Mutex
+
+	connMu sync.Mutex
+	conns  map[net.Conn]bool
+}
+
+// New creates a server of the store. If token is not empty,
+// clients must send it with AUTH before any other command.
+func New(db *store.Store, token string) *Server {
+	return &Server{db: db, token: token, conns: map[net.Conn]bool{}}
+}
+
+// Serve accepts the connections until l is closed,
+// then closes the open ones.
+func (s *Server) Serve(l net.Listener) error {
+	done := make(chan struct{})
+	defer close(done)
+	go s.purge(done)
+
+	defer func() {
+		s.connMu.Lock()
+		for c := range s.conns {
+			c.Close()
+		}
+		s.connMu.Unlock()
+	}()
+
+	for {
+		c, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		s.connMu.Lock()
+		s.conns[c] = true
+		s.connMu.Unlock()
+
+		go s.handle(c)
+	}
+}
+
+func (s *Server) purge(done chan struct{}) {
+	t := time.NewTicker(purgeInterval)
+	defer t.Stop()
+
+	for {
+		s.db.PurgeExpired()
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// conn is the state of a client connection.
+type conn struct {
+	bucket string
+	authed bool
+	w      writer
+}
+
+func (s *Server) handle(c net.Conn) {
+	br := bufio.NewReader(c)
+	cc := &conn{
+		bucket: DefaultBucket,
+		authed: len(s.token) == 0,
+		w:      writer{bufio.NewWriter(c)},
+	}
+
+	defer func() {
+		// a failing command must not take down the server
+		if r := recover(); r != nil {
+			log.Printf("resp: panic serving %s: %v\n%s", c.RemoteAddr(), r, debug.Stack())
+			cc.w.error("ERR internal error")
+			cc.w.Flush()
+		}
+		c.Close()
+		s.connMu.Lock()
+		delete(s.conns, c)
+		s.connMu.Unlock()
+	}()
+
+	for {
+		lim := cmdLimits
+		if !cc.authed {
+			lim = authLimits
+		}
+
+		args, err := readCommand(br, lim)
+		if err == errProtocol {
+			cc.w.error("ERR " + err.Error())
+			cc.w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		name := strings.ToUpper(string(args[0]))
+		if name == "QUIT" {
+			cc.w.simple("OK")
+			cc.w.Flush()
+			return
+		}
+
+		s.exec(cc, name, args[1:])
+		if br.Buffered() == 0 {
+			if err := cc.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// command is a command handler with its minimum number of arguments.
+type command struct {
+	arity int
+	fn    func(s *Server, c *conn, args [][]byte)
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":    {0, (*Server).ping},
+		"ECHO":    {1, func(s *Server, c *conn, args [][]byte) { c.w.bulk(args[0]) }},
+		"SELECT":  {1, (*Server).selectBucket},
+		"COMMAND": {0, func(s *Server, c *conn, args [][]byte) { c.w.array(0) }},
+		"CLIENT":  {1, func(s *Server, c *conn, args [][]byte) { c.w.simple("OK") }},
+		"DBSIZE":  {0, (*Server).dbsize},
+		"GET":     {1, (*Server).get},
+		"SET":     {2, (*Server).set},
+		"DEL":     {1, (*Server).del},
+		"EXISTS":  {1, (*Server).existsCmd},
+		"TYPE":    {1, (*Server).typeOf},
+		"KEYS":    {1, (*Server).keys},
+		"SCAN":    {1, (*Server).scan},
+		"INCR":    {1, func(s *Server, c *conn, args [][]byte) { s.incr(c, args[0], 1) }},
+		"DECR":    {1, func(s *Server, c *conn, args [][]byte) { s.incr(c, args[0], -1) }},
+		"INCRBY":  {2, func(s *Server, c *conn, args [][]byte) { s.incrBy(c, args, 1) }},
+		"DECRBY":  {2, func(s *Server, c *conn, args [][]byte) { s.incrBy(c, args, -1) }},
+		"EXPIRE":  {2, func(s *Server, c *conn, args [][]byte) { s.expire(c, args, time.Second) }},
+		"PEXPIRE": {2, func(s *Server, c *conn, args [][]byte) { s.expire(c, args, time.Millisecond) }},
+		"TTL":     {1, func(s *Server, c *conn, args [][]byte) { s.ttl(c, args, time.Second) }},
+		"PTTL":    {1, func(s *Server, c *conn, args [][]byte) { s.ttl(c, args, time.Millisecond) }},
+		"PERSIST": {1, (*Server).persist},
+		"HGET":    {2, hash((*Server).hget)},
+		"HSET":    {3, hash((*Server).hset)},
+		"HDEL":    {2, hash((*Server).hdel)},
+		"HEXISTS": {2, hash((*Server).hexists)},
+		"HLEN":    {1, hash((*Server).hlen)},
+		"HKEYS":   {1, hash((*Server).hkeys)},
+		"HGETALL": {1, hash((*Server).hgetall)},
+	}
+}
+
+// hash checks the hash name, the first argument, before running fn.
+func hash(fn func(s *Server, c *conn, args [][]byte)) func(s *Server, c *conn, args [][]byte) {
+	return func(s *Server, c *conn, args [][]byte) {
+		if !validBucket(args[0]) {
+			c.w.error(errBucket)
+			return
+		}
+		fn(s, c, args)
+	}
+}
+
+func (s *Server) exec(c *conn, name string, args [][]byte) {
+	if name == "AUTH" {
+		s.auth(c, args)
+		return
+	}
+	if !c.authed {
+		c.w.error("NOAUTH Authentication required.")
+		return
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		c.w.error("ERR unknown command '" + strings.ToLower(name) + "'")
+		return
+	}
+	if len(args) < cmd.arity {
+		c.w.error("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+		return
+	}
+
+	cmd.fn(s, c, args)
+}
@@ -0,0 +1,279 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lucasepe/kvs/internal/store"
+)
+
+// client is a minimal RESP client.
+type client struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func (c *client) do(t *testing.T, args ...string) interface{} {
+	t.Helper()
+
+	fmt.Fprintf(c.conn, "*%d\r\n", len(args))
+	for _, el := range args {
+		fmt.Fprintf(c.conn, "$%d\r\n%s\r\n", len(el), el)
+	}
+
+	res, err := c.read()
+	if err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	return res
+}
+
+// read returns a reply as string, int64, nil, []interface{}
+// or, for errors, as an error.
+func (c *client) read() (interface{}, error) {
+	line, err := c.br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return fmt.Errorf("%s", line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.br, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		res := []interface{}{}
+		for i := 0; i < n; i++ {
+			el, err := c.read()
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, el)
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("unexpected reply: %q", line)
+}
+
+func newTestServer(t *testing.T, token string) (*client, *store.Store) {
+	db, err := store.New(store.Options{Path: filepath.Join(t.TempDir(), "test.kvs")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go New(db, token).Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &client{conn: conn, br: bufio.NewReader(conn)}, db
+}
+
+func expect(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %#v, got %#v", want, got)
+	}
+}
+
+func TestStrings(t *testing.T) {
+	c, db := newTestServer(t, "")
+
+	expect(t, c.do(t, "PING"), "PONG")
+	expect(t, c.do(t, "GET", "a"), nil)
+	expect(t, c.do(t, "SET", "a", "hello"), "OK")
+	expect(t, c.do(t, "GET", "a"), "hello")
+	expect(t, c.do(t, "SET", "a", "again", "NX"), nil)
+	expect(t, c.do(t, "SET", "b", "x", "XX"), nil)
+	expect(t, c.do(t, "EXISTS", "a", "b"), int64(1))
+
+	if v, _ := db.InBucket(DefaultBucket).Get("a"); string(v) != "hello" {
+		t.Fatalf("expected the value in bucket %q, got %q", DefaultBucket, v)
+	}
+
+	expect(t, c.do(t, "INCR", "n"), int64(1))
+	expect(t, c.do(t, "INCRBY", "n", "10"), int64(11))
+	expect(t, c.do(t, "DECR", "n"), int64(10))
+	expect(t, c.do(t, "INCR", "a"), fmt.Errorf(errNotInteger))
+
+	expect(t, c.do(t, "KEYS", "*"), []interface{}{"a", "n"})
+	expect(t, c.do(t, "KEYS", "[m-z]"), []interface{}{"n"})
+	expect(t, c.do(t, "SCAN", "0", "COUNT", "1"), []interface{}{"1", []interface{}{"a"}})
+	expect(t, c.do(t, "SCAN", "1", "COUNT", "1"), []interface{}{"0", []interface{}{"n"}})
+	expect(t, c.do(t, "TYPE", "a"), "string")
+	expect(t, c.do(t, "DBSIZE"), int64(2))
+
+	expect(t, c.do(t, "TTL", "a"), int64(-1))
+	expect(t, c.do(t, "TTL", "missing"), int64(-2))
+	expect(t, c.do(t, "EXPIRE", "a", "100"), int64(1))
+	expect(t, c.do(t, "TTL", "a"), int64(100))
+	expect(t, c.do(t, "INCR", "n"), int64(11))
+	expect(t, c.do(t, "PERSIST", "a"), int64(1))
+	expect(t, c.do(t, "PTTL", "a"), int64(-1))
+	expect(t, c.do(t, "SET", "a", "v", "EX", "10"), "OK")
+	expect(t, c.do(t, "TTL", "a"), int64(10))
+	expect(t, c.do(t, "SET", "a", "w"), "OK")
+	expect(t, c.do(t, "TTL", "a"), int64(-1))
+	expect(t, c.do(t, "EXPIRE", "a", "0"), int64(1))
+	expect(t, c.do(t, "GET", "a"), nil)
+
+	expect(t, c.do(t, "DEL", "n", "missing"), int64(1))
+	expect(t, c.do(t, "SELECT", "other"), "OK")
+	expect(t, c.do(t, "KEYS", "*"), []interface{}{})
+	expect(t, c.do(t, "NOPE"), fmt.Errorf("ERR unknown command 'nope'"))
+}
+
+func TestHashes(t *testing.T) {
+	c, db := newTestServer(t, "")
+
+	expect(t, c.do(t, "HGET", "user", "name"), nil)
+	expect(t, c.do(t, "HSET", "user", "name", "ada", "lang", "go"), int64(2))
+	expect(t, c.do(t, "HSET", "user", "lang", "c"), int64(0))
+	expect(t, c.do(t, "HGET", "user", "lang"), "c")
+	expect(t, c.do(t, "HEXISTS", "user", "name"), int64(1))
+	expect(t, c.do(t, "HLEN", "user"), int64(2))
+	expect(t, c.do(t, "HGETALL", "user"), []interface{}{"lang", "c", "name", "ada"})
+	expect(t, c.do(t, "HDEL", "user", "lang", "missing"), int64(1))
+	expect(t, c.do(t, "HKEYS", "user"), []interface{}{"name"})
+
+	if got := db.Buckets(); !reflect.DeepEqual(got, []string{"user"}) {
+		t.Fatalf("expected the hash as bucket, got %v", got)
+	}
+}
+
+func TestReservedBuckets(t *testing.T) {
+	c, db := newTestServer(t, "")
+
+	expect(t, c.do(t, "SELECT", "__kvs_meta__"), fmt.Errorf(errBucket))
+	expect(t, c.do(t, "SET", "version", "x"), "OK")
+	expect(t, c.do(t, "HSET", "__kvs_meta__", "version", "x"), fmt.Errorf(errBucket))
+	expect(t, c.do(t, "HGET", "__kvs_meta__", "version"), fmt.Errorf(errBucket))
+	expect(t, c.do(t, "HDEL", "__kvs_chunks__", "x"), fmt.Errorf(errBucket))
+	expect(t, c.do(t, "HGETALL", "__kvs_names__"), fmt.Errorf(errBucket))
+
+	// the value has been written in the default bucket
+	if v, _ := db.InBucket(DefaultBucket).Get("version"); string(v) != "x" {
+		t.Fatalf("expected the value in bucket %q, got %q", DefaultBucket, v)
+	}
+	if v, _ := db.InBucket("__kvs_meta__").Get("version"); string(v) == "x" {
+		t.Fatal("the store version has been overwritten")
+	}
+}
+
+func TestPanic(t *testing.T) {
+	commands["PANIC"] = command{0, func(s *Server, c *conn, args [][]byte) { panic("boom") }}
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		delete(commands, "PANIC")
+		log.SetOutput(os.Stderr)
+	})
+
+	c, _ := newTestServer(t, "")
+	expect(t, c.do(t, "PANIC"), fmt.Errorf("ERR internal error"))
+	if _, err := c.read(); err != io.EOF {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	c, _ := newTestServer(t, "secret")
+
+	expect(t, c.do(t, "GET", "a"), fmt.Errorf("NOAUTH Authentication required."))
+	expect(t, c.do(t, "AUTH", "wrong"), fmt.Errorf("WRONGPASS invalid username-password pair or user is disabled."))
+	expect(t, c.do(t, "AUTH", "default", "secret"), "OK")
+	expect(t, c.do(t, "GET", "a"), nil)
+
+	// inline commands, as sent by telnet
+	fmt.Fprintf(c.conn, "PING hi\r\n")
+	if res, err := c.read(); err != nil || res != "hi" {
+		t.Fatalf("expected 'hi', got %v, %v", res, err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"h?llo", "hello", true},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hallo", false},
+		{"user:*", "user:1/a", true},
+		{"a*", "ba", false},
+	}
+
+	for _, tt := range tests {
+		if got := match(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("match(%q, %q): expected %v", tt.pattern, tt.s, tt.want)
+		}
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	c, _ := newTestServer(t, "secret")
+	addr := c.conn.RemoteAddr().String()
+
+	for _, req := range []string{
+		"*-1\r\n",
+		"*2\r\n$-1\r\n",
+		"*100\r\n",
+		"*1\r\n$100000\r\n",
+	} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &client{conn: conn, br: bufio.NewReader(conn)}
+
+		fmt.Fprint(conn, req)
+		res, err := c.read()
+		conn.Close()
+		if err != nil {
+			t.Fatalf("%q: %v", req, err)
+		}
+		if e, ok := res.(error); !ok || !strings.HasPrefix(e.Error(), "ERR Protocol error") {
+			t.Fatalf("%q: expected a protocol error, got %v", req, res)
+		}
+	}
+
+	// the server is still serving
+	expect(t, c.do(t, "AUTH", "secret"), "OK")
+	expect(t, c.do(t, "PING"), "PONG")
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lucasepe/kvs/internal/store"
+)
+
+// Client talks to a server listening on 'unix:/path' or on a TCP address.
+type Client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// NewClient creates a client of the server listening at addr.
+func NewClient(addr, token string) *Client {
+	if !strings.HasPrefix(addr, "unix:") {
+		base := addr
+		if !strings.Contains(base, "://") {
+			base = "http://" + base
+		}
+		return &Client{base: strings.TrimSuffix(base, "/"), token: token, http: http.DefaultClient}
+	}
+
+	path := strings.TrimPrefix(addr, "unix:")
+	tr := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	return &Client{base: "http://kvs", token: token, http: &http.Client{Transport: tr}}
+}
+
+// Get returns the raw value of the key, to be closed once read.
+func (c *Client) Get(bucket, key string) (io.ReadCloser, error) {
+	res, err := c.do(http.MethodGet, keyPath(bucket, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// Put stores the raw value read from r.
+func (c *Client) Put(bucket, key string, r io.Reader) error {
+	return c.discard(c.do(http.MethodPut, keyPath(bucket, key), r))
+}
+
+// Delete deletes the key.
+func (c *Client) Delete(bucket, key string) error {
+	return c.discard(c.do(http.MethodDelete, keyPath(bucket, key), nil))
+}
+
+// DeleteBucket deletes the bucket and all its keys.
+func (c *Client) DeleteBucket(bucket string) error {
+	return c.discard(c.do(http.MethodDelete, "buckets/"+url.PathEscape(bucket), nil))
+}
+
+// Buckets returns the names of the buckets.
+func (c *Client) Buckets() ([]string, error) {
+	return c.list("buckets")
+}
+
+// Keys returns the keys of the bucket.
+func (c *Client) Keys(bucket string) ([]string, error) {
+	return c.list("buckets/" + url.PathEscape(bucket) + "/keys")
+}
+
+// Backup writes a consistent snapshot of the store to w.
+func (c *Client) Backup(w io.Writer) (int64, error) {
+	res, err := c.do(http.MethodGet, "export", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	return io.Copy(w, res.Body)
+}
+
+func (c *Client) list(path string) ([]string, error) {
+	res, err := c.do(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var names []string
+	err = json.NewDecoder(res.Body).Decode(&names)
+	return names, err
+}
+
+func (c *Client) discard(res *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// do sends the request and turns the error replies
+// back into the errors of the store.
+func (c *Client) do(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.base+apiPrefix+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+
+	var reply errorReply
+	if err := json.NewDecoder(res.Body).Decode(&reply); err != nil || len(reply.Error) == 0 {
+		return nil, fmt.Errorf("kvs: unexpected server reply: %s", res.Status)
+	}
+
+	for _, el := range []error{store.ErrKeyNotFound, store.ErrBucketNotFound, store.ErrTooLarge} {
+		if reply.Error == el.Error() {
+			return nil, el
+		}
+	}
+	return nil, errors.New(reply.Error)
+}
+
+func keyPath(bucket, key string) string {
+	return "buckets/" + url.PathEscape(bucket) + "/keys/" + url.PathEscape(key)
+}
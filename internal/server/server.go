@@ -0,0 +1,292 @@
+// Package server exposes a store through a REST API, so that many
+// processes can share it while bbolt allows a single writer:
+//
+//	GET    /v1/buckets                  list the buckets
+//	DELETE /v1/buckets/{bucket}         delete a bucket
+//	GET    /v1/buckets/{bucket}/keys    list the keys of a bucket
+//	GET    /v1/buckets/{bucket}/keys/{key}
+//	PUT    /v1/buckets/{bucket}/keys/{key}
+//	DELETE /v1/buckets/{bucket}/keys/{key}
+//	GET    /v1/export                   consistent snapshot of the store
+//
+// Values are exchanged as they are stored (see store.GetRaw), so
+// they are encrypted and decrypted by the clients. Every request
+// must carry the token as 'Authorization: Bearer <token>'.
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lucasepe/kvs/internal/agent"
+	"github.com/lucasepe/kvs/internal/store"
+	bolt "go.etcd.io/bbolt"
+)
+
+const apiPrefix = "/v1/"
+
+// Server is the http.Handler of the API.
+type Server struct {
+	db      *store.Store
+	token   string
+	maxSize int64
+}
+
+// New creates the API of the store. The token is required by every
+// request; values larger than maxSize are refused, if greater than zero.
+func New(db *store.Store, token string, maxSize int64) *Server {
+	return &Server{db: db, token: token, maxSize: maxSize}
+}
+
+// Listen listens on a TCP address or on 'unix:/path', a socket
+// readable and writable only by the current user, created as the
+// agent one (see agent.Listen): its directory must be private.
+func Listen(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, "unix:") {
+		return net.Listen("tcp", addr)
+	}
+	return agent.Listen(strings.TrimPrefix(addr, "unix:"))
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, errors.New("kvs: invalid token"))
+		return
+	}
+
+	parts, err := splitPath(r.URL.EscapedPath())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// the reserved buckets hold the internals of the store
+	if len(parts) > 1 && parts[0] == "buckets" && store.IsReserved(parts[1]) {
+		writeError(w, http.StatusNotFound, store.ErrBucketNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0] == "export":
+		s.handle(w, r, http.MethodGet, s.export)
+	case len(parts) == 1 && parts[0] == "buckets":
+		s.handle(w, r, http.MethodGet, s.buckets)
+	case len(parts) == 2 && parts[0] == "buckets":
+		s.handle(w, r, http.MethodDelete, func(w http.ResponseWriter, r *http.Request) error {
+			return s.deleteBucket(w, parts[1])
+		})
+	case len(parts) == 3 && parts[0] == "buckets" && parts[2] == "keys":
+		s.handle(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) error {
+			return writeJSON(w, nonNil(s.db.InBucket(parts[1]).Keys()))
+		})
+	case len(parts) == 4 && parts[0] == "buckets" && parts[2] == "keys":
+		s.key(w, r, parts[1], parts[3])
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("kvs: unknown path %s", r.URL.Path))
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return validToken(strings.TrimPrefix(auth, "Bearer "), s.token)
+}
+
+// validToken compares the tokens in constant time;
+// an empty token is never valid.
+func validToken(got, want string) bool {
+	return len(want) > 0 && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// handle runs fn if the request method is the expected one.
+func (s *Server) handle(w http.ResponseWriter, r *http.Request, method string, fn func(http.ResponseWriter, *http.Request) error) {
+	if r.Method != method {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("kvs: method %s not allowed", r.Method))
+		return
+	}
+	if err := fn(w, r); err != nil {
+		writeError(w, statusOf(err), err)
+	}
+}
+
+func (s *Server) key(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	db := s.db.InBucket(bucket)
+
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		err = s.get(w, db, key)
+	case http.MethodPut:
+		if err = s.put(w, r, db, key); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case http.MethodDelete:
+		if err = db.Delete(key); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		err = fmt.Errorf("kvs: method %s not allowed", r.Method)
+		writeError(w, http.StatusMethodNotAllowed, err)
+		return
+	}
+
+	if err != nil {
+		writeError(w, statusOf(err), err)
+	}
+}
+
+// get writes the value, aborting the response if it fails
+// once started: an error reply would be taken as the value.
+func (s *Server) get(w http.ResponseWriter, db *store.Store, key string) error {
+	info, err := db.Stat(key)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+
+	cw := &countingWriter{w: w}
+	if _, err := db.GetRaw(key, cw); err != nil {
+		if cw.n > 0 {
+			panic(http.ErrAbortHandler)
+		}
+		return err
+	}
+	return nil
+}
+
+// put stores the request body, first spooled next to the store:
+// the write transaction must not wait for the client.
+func (s *Server) put(w http.ResponseWriter, r *http.Request, db *store.Store, key string) error {
+	body := r.Body
+	if s.maxSize > 0 {
+		body = http.MaxBytesReader(w, body, s.maxSize)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(s.db.Path()), ".put-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	if _, err := io.Copy(f, body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return store.ErrTooLarge
+		}
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err = db.SetRaw(key, f, s.maxSize)
+	return err
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (s *Server) buckets(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, nonNil(s.db.Buckets()))
+}
+
+func (s *Server) deleteBucket(w http.ResponseWriter, bucket string) error {
+	err := s.db.DeleteBucket(bucket)
+	if errors.Is(err, bolt.ErrBucketNotFound) {
+		return store.ErrBucketNotFound
+	}
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) export(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	cw := &countingWriter{w: w}
+	if _, err := s.db.Backup(cw); err != nil {
+		if cw.n > 0 {
+			panic(http.ErrAbortHandler)
+		}
+		return err
+	}
+	return nil
+}
+
+// splitPath returns the unescaped segments of the path after the
+// API prefix: the keys may hold escaped slashes.
+func splitPath(path string) ([]string, error) {
+	if !strings.HasPrefix(path, apiPrefix) {
+		return nil, nil
+	}
+	rest := strings.TrimPrefix(path, apiPrefix)
+
+	parts := strings.Split(strings.TrimSuffix(rest, "/"), "/")
+	for i, el := range parts {
+		v, err := url.PathUnescape(el)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = v
+	}
+	return parts, nil
+}
+
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, store.ErrKeyNotFound), errors.Is(err, store.ErrBucketNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, store.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type errorReply struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorReply{err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(v)
+}
+
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lucasepe/kvs/internal/store"
+)
+
+func newTestServer(t *testing.T) (*Client, *store.Store) {
+	db, err := store.New(store.Options{Path: filepath.Join(t.TempDir(), "test.kvs")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ts := httptest.NewServer(New(db, "secret", 16))
+	t.Cleanup(ts.Close)
+
+	return NewClient(ts.URL, "secret"), db
+}
+
+func TestServer(t *testing.T) {
+	c, db := newTestServer(t)
+
+	if err := c.Put("google", "a/b c", strings.NewReader("\x00value")); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.Get("google", "a/b c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "\x00value" {
+		t.Fatalf("expected the raw value, got %q", got)
+	}
+
+	if v, err := db.InBucket("google").Get("a/b c"); err != nil || string(v) != "value" {
+		t.Fatalf("expected the value in the store, got %q, %v", v, err)
+	}
+
+	keys, err := c.Keys("google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{"a/b c"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	buckets, err := c.Buckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(buckets, []string{"google"}) {
+		t.Fatalf("unexpected buckets: %v", buckets)
+	}
+
+	var snap bytes.Buffer
+	if n, err := c.Backup(&snap); err != nil || n == 0 {
+		t.Fatalf("expected a snapshot, got %d bytes, %v", n, err)
+	}
+
+	if err := c.Put("google", "big", strings.NewReader("\x00"+strings.Repeat("x", 16))); err != store.ErrTooLarge {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+
+	if err := c.Delete("google", "a/b c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get("google", "a/b c"); err != store.ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if err := c.Delete("nope", "a/b c"); err != store.ErrBucketNotFound {
+		t.Fatalf("expected ErrBucketNotFound, got %v", err)
+	}
+
+	if err := c.DeleteBucket("google"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteBucket("google"); err != store.ErrBucketNotFound {
+		t.Fatalf("expected ErrBucketNotFound, got %v", err)
+	}
+}
+
+func TestServerSlowPut(t *testing.T) {
+	c, _ := newTestServer(t)
+
+	// the body of a slow client must not hold the write transaction
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- c.Put("google", "slow", pr) }()
+	pw.Write([]byte("\x00abc"))
+
+	put := make(chan error, 1)
+	go func() { put <- c.Put("google", "fast", strings.NewReader("\x00value")) }()
+	select {
+	case err := <-put:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the write waited for the slow client")
+	}
+
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.Get("google", "slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(r)
+	r.Close()
+	if string(got) != "\x00abc" {
+		t.Fatalf("expected the raw value, got %q", got)
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	db, err := store.New(store.Options{Path: filepath.Join(t.TempDir(), "test.kvs")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	srv := New(db, "secret", 0)
+	for _, auth := range []string{"", "Bearer", "Bearer wrong", "secret"} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/buckets", nil)
+		if len(auth) > 0 {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%q: expected 401, got %d", auth, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/buckets", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+}
+
+func TestServerReserved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.kvs")
+	db, err := store.New(store.Options{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	srv := New(db, "secret", 0)
+	requests := []struct{ method, path string }{
+		{http.MethodDelete, "/v1/buckets/__kvs_meta__"},
+		{http.MethodGet, "/v1/buckets/__kvs_meta__/keys"},
+		{http.MethodGet, "/v1/buckets/__kvs_meta__/keys/version"},
+		{http.MethodPut, "/v1/buckets/__kvs_chunks__/keys/x"},
+		{http.MethodDelete, "/v1/buckets/__kvs_names__/keys/x"},
+	}
+	for _, el := range requests {
+		req := httptest.NewRequest(el.method, el.path, strings.NewReader("x"))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("%s %s: expected 404, got %d", el.method, el.path, rec.Code)
+		}
+	}
+
+	db.Close()
+	if db, err = store.New(store.Options{Path: path}); err != nil {
+		t.Fatalf("expected the store to be intact, got %v", err)
+	}
+	db.Close()
+}
+
+func TestListenUnix(t *testing.T) {
+	db, err := store.New(store.Options{Path: filepath.Join(t.TempDir(), "test.kvs")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// the socket directory must be private
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen("unix:" + filepath.Join(dir, "kvs.sock")); err == nil {
+		t.Fatal("expected an error listening in a shared directory")
+	}
+
+	addr := "unix:" + filepath.Join(dir, "kvs", "kvs.sock")
+	l, err := Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: New(db, "secret", 0)}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	c := NewClient(addr, "secret")
+	if err := c.Put("b", "k", strings.NewReader("\x00v")); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := c.Keys("b"); err != nil || len(keys) != 1 {
+		t.Fatalf("expected one key, got %v, %v", keys, err)
+	}
+
+	if _, err := Listen(addr); err == nil {
+		t.Fatal("expected an error listening twice on the same socket")
+	}
+}
@@ -0,0 +1,433 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucasepe/kvs/internal/store"
+	bolt "go.etcd.io/bbolt"
+)
+
+// VaultMount is the mount of the KV v2 secrets engine served by Vault.
+const VaultMount = "secret"
+
+// Vault serves the store through the HTTP API of the KV v2 secrets
+// engine of HashiCorp Vault, mounted at 'secret/': each secret is a
+// bucket, named as its path, and each of its fields is a key.
+//
+// The store keeps no history, so every secret has a single version,
+// reported as version 1 and as created when the server started.
+type Vault struct {
+	db      *store.Store
+	token   string
+	maxSize int64
+	started time.Time
+
+	// mu makes the check-and-set of the writes atomic
+	mu sync.Mutex
+}
+
+// NewVault creates the Vault API of the store, accepting the token
+// as 'X-Vault-Token' header (or as 'Authorization: Bearer'); writes
+// larger than maxSize are refused, if greater than zero.
+func NewVault(db *store.Store, token string, maxSize int64) *Vault {
+	return &Vault{db: db, token: token, maxSize: maxSize, started: time.Now().UTC()}
+}
+
+func (v *Vault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+
+	if path == "/v1/sys/health" {
+		writeJSON(w, map[string]interface{}{
+			"initialized": true, "sealed": false, "standby": false,
+		})
+		return
+	}
+
+	token := r.Header.Get("X-Vault-Token")
+	if len(token) == 0 {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if !validToken(token, v.token) {
+		writeVaultError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	const (
+		mounts   = "/v1/sys/internal/ui/mounts/" + VaultMount
+		data     = "/v1/" + VaultMount + "/data/"
+		metadata = "/v1/" + VaultMount + "/metadata/"
+	)
+
+	switch {
+	case path == mounts || strings.HasPrefix(path, mounts+"/"):
+		// used by the vault CLI to find the engine version
+		v.reply(w, map[string]interface{}{
+			"path": VaultMount + "/", "type": "kv",
+			"options": map[string]string{"version": "2"},
+		})
+	case strings.HasPrefix(path, data):
+		v.data(w, r, strings.Trim(strings.TrimPrefix(path, data), "/"))
+	case strings.HasPrefix(path, metadata) || path+"/" == metadata:
+		v.metadata(w, r, strings.Trim(strings.TrimPrefix(path, metadata), "/"))
+	default:
+		writeVaultError(w, http.StatusNotFound, "no handler for route '"+strings.TrimPrefix(path, "/v1/")+"'")
+	}
+}
+
+func (v *Vault) data(w http.ResponseWriter, r *http.Request, path string) {
+	path = cleanPath(path)
+	if len(path) == 0 || store.IsReserved(path) {
+		writeVaultError(w, http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if ver := r.URL.Query().Get("version"); len(ver) > 0 && ver != "0" && ver != "1" {
+			writeVaultError(w, http.StatusNotFound)
+			return
+		}
+
+		fields, err := v.read(path)
+		if err != nil {
+			writeVaultError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if fields == nil {
+			writeVaultError(w, http.StatusNotFound)
+			return
+		}
+		v.reply(w, map[string]interface{}{
+			"data":     fields,
+			"metadata": v.version(),
+		})
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		v.write(w, r, path)
+	case http.MethodDelete:
+		v.delete(w, path)
+	default:
+		writeVaultError(w, http.StatusMethodNotAllowed)
+	}
+}
+
+func (v *Vault) metadata(w http.ResponseWriter, r *http.Request, path string) {
+	path = cleanPath(path)
+	if r.Method == "LIST" || (r.Method == http.MethodGet && r.URL.Query().Get("list") == "true") {
+		v.list(w, path)
+		return
+	}
+	if len(path) == 0 || store.IsReserved(path) {
+		writeVaultError(w, http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		fields, err := v.read(path)
+		if err != nil {
+			writeVaultError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if fields == nil {
+			writeVaultError(w, http.StatusNotFound)
+			return
+		}
+
+		created := v.started.Format(time.RFC3339Nano)
+		v.reply(w, map[string]interface{}{
+			"cas_required":         false,
+			"created_time":         created,
+			"updated_time":         created,
+			"current_version":      1,
+			"oldest_version":       1,
+			"max_versions":         0,
+			"delete_version_after": "0s",
+			"custom_metadata":      nil,
+			"versions":             map[string]interface{}{"1": v.version()},
+		})
+	case http.MethodDelete:
+		v.delete(w, path)
+	default:
+		writeVaultError(w, http.StatusMethodNotAllowed)
+	}
+}
+
+// vaultWrite is the body of a write: PATCH merges the fields,
+// deleting the null ones, the other methods replace them all.
+type vaultWrite struct {
+	Data    map[string]json.RawMessage `json:"data"`
+	Options struct {
+		CAS *int `json:"cas"`
+	} `json:"options"`
+}
+
+func (v *Vault) write(w http.ResponseWriter, r *http.Request, path string) {
+	body := r.Body
+	if v.maxSize > 0 {
+		body = http.MaxBytesReader(w, body, v.maxSize)
+	}
+
+	var req vaultWrite
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeVaultError(w, http.StatusRequestEntityTooLarge, "request too large")
+			return
+		}
+		writeVaultError(w, http.StatusBadRequest, "error parsing JSON")
+		return
+	}
+	if req.Data == nil {
+		writeVaultError(w, http.StatusBadRequest, "no data provided")
+		return
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	db := v.db.InBucket(path)
+	current := db.Keys()
+
+	patch := r.Method == http.MethodPatch
+	if patch && len(current) == 0 {
+		writeVaultError(w, http.StatusNotFound)
+		return
+	}
+	version := 0
+	if len(current) > 0 {
+		version = 1
+	}
+	if cas := req.Options.CAS; cas != nil && *cas != version {
+		writeVaultError(w, http.StatusBadRequest, "check-and-set parameter did not match the current version")
+		return
+	}
+
+	types := map[string]json.RawMessage{}
+	if patch {
+		var err error
+		if types, err = fieldTypes(db); err != nil {
+			writeVaultError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	values := make(map[string][]byte, len(req.Data)+1)
+	for k, raw := range req.Data {
+		name := db.KeyPolicy().Normalize(k)
+		values[k], types[name] = fieldValue(raw)
+		if types[name] == nil {
+			delete(types, name)
+		}
+	}
+	values[typesKey] = nil
+	if len(types) > 0 {
+		values[typesKey], _ = json.Marshal(types)
+	}
+	if err := db.SetAll(values, !patch); err != nil {
+		writeVaultError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	v.reply(w, v.version())
+}
+
+func (v *Vault) delete(w http.ResponseWriter, path string) {
+	err := v.db.DeleteBucket(path)
+	if err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+		writeVaultError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// list returns the secrets and the folders, ending with '/',
+// right under the path.
+func (v *Vault) list(w http.ResponseWriter, path string) {
+	prefix := ""
+	if len(path) > 0 {
+		prefix = path + "/"
+	}
+
+	seen := map[string]bool{}
+	var keys []string
+	for _, el := range v.db.Buckets() {
+		if !strings.HasPrefix(el, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(el, prefix)
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			name = name[:i+1]
+		}
+		if !seen[name] {
+			seen[name] = true
+			keys = append(keys, name)
+		}
+	}
+	if len(keys) == 0 {
+		writeVaultError(w, http.StatusNotFound)
+		return
+	}
+	sort.Strings(keys)
+
+	v.reply(w, map[string]interface{}{"keys": keys})
+}
+
+// read returns the fields of the secret, nil if it does not exist.
+func (v *Vault) read(path string) (map[string]interface{}, error) {
+	db := v.db.InBucket(path)
+
+	keys := db.Keys()
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	types, err := fieldTypes(db)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]interface{}, len(keys))
+	for _, el := range keys {
+		if el == db.KeyPolicy().Normalize(typesKey) {
+			continue
+		}
+
+		head, err := db.Peek(el, 1)
+		if err == store.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if store.IsEncrypted(head) && db.CheckKey() == store.ErrKeyRequired {
+			return nil, errors.New("'" + el + "' is encrypted: the server needs the secret phrase")
+		}
+
+		dat, err := db.Get(el)
+		if err != nil {
+			return nil, err
+		}
+		if dat == nil {
+			continue
+		}
+
+		if store.KindOf(head) == store.KindBlob {
+			res[el] = fieldOf(dat, types[el])
+			continue
+		}
+		items, err := store.DecodeCollection(dat)
+		if err != nil {
+			return nil, err
+		}
+		list := make([]string, len(items))
+		for i, it := range items {
+			list[i] = string(it)
+		}
+		res[el] = list
+	}
+
+	return res, nil
+}
+
+func (v *Vault) version() map[string]interface{} {
+	return map[string]interface{}{
+		"created_time":    v.started.Format(time.RFC3339Nano),
+		"custom_metadata": nil,
+		"deletion_time":   "",
+		"destroyed":       false,
+		"version":         1,
+	}
+}
+
+// reply writes the response envelope of Vault.
+func (v *Vault) reply(w http.ResponseWriter, data interface{}) {
+	writeJSON(w, map[string]interface{}{
+		"request_id":     "",
+		"lease_id":       "",
+		"renewable":      false,
+		"lease_duration": 0,
+		"data":           data,
+		"wrap_info":      nil,
+		"warnings":       nil,
+		"auth":           nil,
+	})
+}
+
+func writeVaultError(w http.ResponseWriter, status int, msgs ...string) {
+	if msgs == nil {
+		msgs = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string][]string{"errors": msgs})
+}
+
+// cleanPath returns the secret path without empty segments,
+// which is the name of its bucket.
+func cleanPath(path string) string {
+	var parts []string
+	for _, el := range strings.Split(path, "/") {
+		if len(el) > 0 {
+			parts = append(parts, el)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// typesKey is the key of each secret holding, as one JSON document,
+// the fields written through the API whose values are not strings:
+// only these are read back with their JSON types.
+const typesKey = "__vault_types__"
+
+// fieldTypes returns the typed fields of the secret stored in db;
+// a missing or unreadable document means no typed fields.
+func fieldTypes(db *store.Store) (map[string]json.RawMessage, error) {
+	res := map[string]json.RawMessage{}
+
+	dat, err := db.Get(typesKey)
+	if err == store.ErrKeyNotFound || err == store.ErrBucketNotFound {
+		return res, nil
+	}
+	if err != nil || dat == nil {
+		return res, err
+	}
+	if json.Unmarshal(dat, &res) != nil {
+		return map[string]json.RawMessage{}, nil
+	}
+	return res, nil
+}
+
+// fieldValue returns the value to store for a JSON field: strings as
+// they are, the other values as compact JSON, returned as the typed
+// value as well; null is nil.
+func fieldValue(raw json.RawMessage) ([]byte, json.RawMessage) {
+	if bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []byte(s), nil
+	}
+
+	var buf bytes.Buffer
+	json.Compact(&buf, raw)
+	return buf.Bytes(), buf.Bytes()
+}
+
+// fieldOf returns the JSON field of a stored value: its JSON type
+// only if it still holds the typed value written through the API,
+// anything else, as written by the other commands, as a string.
+func fieldOf(dat []byte, typed json.RawMessage) interface{} {
+	if typed != nil && bytes.Equal(dat, typed) {
+		return json.RawMessage(dat)
+	}
+	return string(dat)
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lucasepe/kvs/internal/store"
+)
+
+func newTestVault(t *testing.T) (func(method, path, body string) (int, map[string]interface{}), *store.Store) {
+	db, err := store.New(store.Options{Path: filepath.Join(t.TempDir(), "test.kvs")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ts := httptest.NewServer(NewVault(db, "root", 1024))
+	t.Cleanup(ts.Close)
+
+	do := func(method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Vault-Token", "root")
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		dat, _ := io.ReadAll(res.Body)
+		var reply map[string]interface{}
+		if len(dat) > 0 {
+			if err := json.Unmarshal(dat, &reply); err != nil {
+				t.Fatalf("%s %s: %v: %s", method, path, err, dat)
+			}
+		}
+		return res.StatusCode, reply
+	}
+
+	return do, db
+}
+
+func TestVault(t *testing.T) {
+	do, db := newTestVault(t)
+
+	if code, _ := do(http.MethodGet, "/v1/secret/data/myapp", ""); code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", code)
+	}
+
+	code, reply := do(http.MethodPost, "/v1/secret/data/myapp", `{"data": {"user": "ada", "port": 5432, "pin": "42", "tls": {"on": true}}, "options": {"cas": 0}}`)
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %v", code, reply)
+	}
+	if v := reply["data"].(map[string]interface{})["version"]; v != float64(1) {
+		t.Fatalf("expected version 1, got %v", v)
+	}
+
+	if v, _ := db.InBucket("myapp").Get("user"); string(v) != "ada" {
+		t.Fatalf("expected the field as key, got %q", v)
+	}
+
+	code, reply = do(http.MethodGet, "/v1/secret/data/myapp", "")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	data := reply["data"].(map[string]interface{})
+	// the JSON types written through the API round-trip
+	want := map[string]interface{}{
+		"user": "ada", "port": float64(5432), "pin": "42",
+		"tls": map[string]interface{}{"on": true},
+	}
+	if !reflect.DeepEqual(data["data"], want) {
+		t.Fatalf("expected %v, got %v", want, data["data"])
+	}
+
+	// the values written by the other commands are strings
+	db.InBucket("myapp").Set("port", []byte("5433"))
+	db.InBucket("myapp").Set("note", []byte(`{"on": true}`))
+	_, reply = do(http.MethodGet, "/v1/secret/data/myapp", "")
+	want["port"], want["note"] = "5433", `{"on": true}`
+	if got := reply["data"].(map[string]interface{})["data"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	if code, _ := do(http.MethodPost, "/v1/secret/data/big", `{"data": {"blob": "`+strings.Repeat("x", 1024)+`"}}`); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", code)
+	}
+	if code, _ := do(http.MethodPost, "/v1/secret/data/myapp", `{"data": {"user": "bob"}, "options": {"cas": 0}}`); code != http.StatusBadRequest {
+		t.Fatalf("expected a check-and-set failure, got %d", code)
+	}
+	if code, _ := do(http.MethodGet, "/v1/secret/data/myapp?version=2", ""); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing version, got %d", code)
+	}
+
+	// PATCH merges, the other writes replace
+	do(http.MethodPatch, "/v1/secret/data/myapp", `{"data": {"port": null, "pin": null, "tls": null, "note": null, "host": "db"}}`)
+	_, reply = do(http.MethodGet, "/v1/secret/data/myapp", "")
+	want = map[string]interface{}{"user": "ada", "host": "db"}
+	if got := reply["data"].(map[string]interface{})["data"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	do(http.MethodPut, "/v1/secret/data/myapp", `{"data": {"user": "bob"}}`)
+	if got := db.InBucket("myapp").Keys(); !reflect.DeepEqual(got, []string{"user"}) {
+		t.Fatalf("expected the fields to be replaced, got %v", got)
+	}
+
+	code, reply = do(http.MethodGet, "/v1/secret/metadata/myapp", "")
+	if code != http.StatusOK || reply["data"].(map[string]interface{})["current_version"] != float64(1) {
+		t.Fatalf("unexpected metadata: %d %v", code, reply)
+	}
+
+	// the paths are not slugified: no collisions
+	do(http.MethodPost, "/v1/secret/data/team/api", `{"data": {"key": "x"}}`)
+	do(http.MethodPost, "/v1/secret/data/team/api/v2", `{"data": {"key": "y"}}`)
+	do(http.MethodPost, "/v1/secret/data/team-api", `{"data": {"key": "z"}}`)
+	_, reply = do(http.MethodGet, "/v1/secret/data/team/api", "")
+	if got := reply["data"].(map[string]interface{})["data"]; !reflect.DeepEqual(got, map[string]interface{}{"key": "x"}) {
+		t.Fatalf("unexpected secret: %v", got)
+	}
+	_, reply = do("LIST", "/v1/secret/metadata/team", "")
+	if got := reply["data"].(map[string]interface{})["keys"]; !reflect.DeepEqual(got, []interface{}{"api", "api/"}) {
+		t.Fatalf("unexpected list: %v", got)
+	}
+	_, reply = do(http.MethodGet, "/v1/secret/metadata/?list=true", "")
+	if got := reply["data"].(map[string]interface{})["keys"]; !reflect.DeepEqual(got, []interface{}{"myapp", "team-api", "team/"}) {
+		t.Fatalf("unexpected list: %v", got)
+	}
+	if code, _ := do(http.MethodGet, "/v1/secret/data/__kvs_meta__", ""); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a reserved bucket, got %d", code)
+	}
+
+	if code, _ := do(http.MethodDelete, "/v1/secret/metadata/myapp", ""); code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", code)
+	}
+	if code, _ := do(http.MethodGet, "/v1/secret/data/myapp", ""); code != http.StatusNotFound {
+		t.Fatalf("expected 404 after the delete, got %d", code)
+	}
+}
+
+func TestVaultToken(t *testing.T) {
+	db, err := store.New(store.Options{Path: filepath.Join(t.TempDir(), "test.kvs")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	srv := NewVault(db, "root", 0)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/secret/data/myapp", nil)
+	req.Header.Set("X-Vault-Token", "wrong")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/sys/health", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the health check without token, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,159 @@
+// Package shamir splits a secret in shares using the Shamir's Secret
+// Sharing scheme over GF(2^8): any threshold shares rebuild the secret,
+// fewer reveal nothing about it.
+//
+// Each share is as long as the secret plus one byte: the values of the
+// polynomials, one per secret byte, followed by their x coordinate.
+package shamir
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidShares is returned when the shares cannot be combined
+	ErrInvalidShares = errors.New("shamir: invalid shares")
+)
+
+// Split divides the secret in n shares, any k of them being
+// required to rebuild it. It requires 2 <= k <= n <= 255.
+func Split(secret []byte, n, k int) ([][]byte, error) {
+	if k < 2 || k > n || n > 255 {
+		return nil, fmt.Errorf("shamir: invalid parameters n=%d k=%d", n, k)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("shamir: empty secret")
+	}
+
+	res := make([][]byte, n)
+	for i := range res {
+		res[i] = make([]byte, len(secret)+1)
+		res[i][len(secret)] = byte(i + 1)
+	}
+
+	coeffs := make([]byte, k)
+	for j, b := range secret {
+		// the constant term is the secret byte,
+		// the others are random
+		coeffs[0] = b
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, err
+		}
+
+		for i := range res {
+			res[i][j] = eval(coeffs, byte(i+1))
+		}
+	}
+
+	return res, nil
+}
+
+// Combine rebuilds the secret from the shares, which must
+// be at least as many as the threshold used by Split.
+// Fewer shares give a wrong secret, not an error.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, ErrInvalidShares
+	}
+
+	size := len(shares[0])
+	if size < 2 {
+		return nil, ErrInvalidShares
+	}
+
+	xs := make([]byte, len(shares))
+	seen := map[byte]bool{}
+	for i, el := range shares {
+		if len(el) != size {
+			return nil, ErrInvalidShares
+		}
+		x := el[size-1]
+		if x == 0 || seen[x] {
+			return nil, ErrInvalidShares
+		}
+		seen[x] = true
+		xs[i] = x
+	}
+
+	res := make([]byte, size-1)
+	ys := make([]byte, len(shares))
+	for j := range res {
+		for i, el := range shares {
+			ys[i] = el[j]
+		}
+		res[j] = interpolate(xs, ys)
+	}
+
+	return res, nil
+}
+
+// eval returns the value of the polynomial at x (Horner's method).
+func eval(coeffs []byte, x byte) byte {
+	var res byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		res = add(mul(res, x), coeffs[i])
+	}
+	return res
+}
+
+// interpolate returns the value at 0 of the polynomial
+// passing through the points (Lagrange interpolation).
+func interpolate(xs, ys []byte) byte {
+	var res byte
+	for i := range xs {
+		basis := byte(1)
+		for j := range xs {
+			if i == j {
+				continue
+			}
+			basis = mul(basis, div(xs[j], add(xs[i], xs[j])))
+		}
+		res = add(res, mul(ys[i], basis))
+	}
+	return res
+}
+
+// Arithmetic in GF(2^8) with the AES polynomial x^8 + x^4 + x^3 + x + 1.
+
+var expTable, logTable = tables()
+
+func tables() (exp [510]byte, log [256]byte) {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i], exp[i+255] = x, x
+		log[x] = byte(i)
+		// multiply by the generator 3
+		x ^= xtime(x)
+	}
+	return exp, log
+}
+
+func xtime(x byte) byte {
+	if x&0x80 != 0 {
+		return x<<1 ^ 0x1b
+	}
+	return x << 1
+}
+
+func add(a, b byte) byte {
+	return a ^ b
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+func div(a, b byte) byte {
+	if b == 0 {
+		panic("shamir: division by zero")
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+255-int(logTable[b])]
+}
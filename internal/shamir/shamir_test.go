@@ -0,0 +1,74 @@
+package shamir
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("expected 5 shares, got %d", len(shares))
+	}
+
+	tests := [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}}
+	for _, tc := range tests {
+		var sub [][]byte
+		for _, i := range tc {
+			sub = append(sub, shares[i])
+		}
+
+		got, err := Combine(sub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, secret) {
+			t.Fatalf("shares %v: expected %q, got %q", tc, secret, got)
+		}
+	}
+
+	got, err := Combine(shares[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got, secret) {
+		t.Fatal("two shares must not rebuild the secret")
+	}
+}
+
+func TestCombineInvalid(t *testing.T) {
+	shares, err := Split([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := [][][]byte{
+		{shares[0]},
+		{shares[0], shares[0]},
+		{shares[0], shares[1][:3]},
+	}
+	for i, tc := range tests {
+		if _, err := Combine(tc); err != ErrInvalidShares {
+			t.Fatalf("#%d: expected ErrInvalidShares, got %v", i, err)
+		}
+	}
+
+	if _, err := Split([]byte("secret"), 2, 3); err == nil {
+		t.Fatal("expected an error with k > n")
+	}
+}
+
+func TestField(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			if got := div(mul(byte(a), byte(b)), byte(b)); got != byte(a) {
+				t.Fatalf("(%d * %d) / %d = %d", a, b, b, got)
+			}
+		}
+	}
+}
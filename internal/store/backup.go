@@ -0,0 +1,112 @@
+package store
+
+import (
+	"bytes"
+	"io"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Backup writes a consistent snapshot of the whole store file to w,
+// without blocking the other readers. It returns the number of bytes written.
+func (s *Store) Backup(w io.Writer) (int64, error) {
+	var n int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+
+	return n, err
+}
+
+// Merge copies, byte for byte, all the values of src into the store,
+// with their expirations, in a single transaction; the existing keys are replaced only if
+// overwrite is true, otherwise they are skipped.
+// It returns the number of copied and skipped values.
+func (s *Store) Merge(src *Store, overwrite bool) (copied, skipped int, err error) {
+	err = src.db.View(func(stx *bolt.Tx) error {
+		return s.db.Update(func(tx *bolt.Tx) error {
+			copied, skipped = 0, 0
+			return src.walkTx(stx, "", func(bucket, key string, v []byte) error {
+				if !overwrite {
+					if _, err := s.get(tx, bucket, key); err == nil {
+						skipped++
+						return nil
+					}
+				}
+
+				b, err := s.createBucket(tx, bucket)
+				if err != nil {
+					return err
+				}
+				if _, err := s.storeValue(tx, b, bucket, key, bytes.NewReader(v), 0); err != nil {
+					return err
+				}
+				if err := s.mergeExpiry(tx, src, stx, bucket, key); err != nil {
+					return err
+				}
+				copied++
+				return nil
+			})
+		})
+	})
+
+	return copied, skipped, err
+}
+
+// mergeExpiry gives the merged key the expiration it has in src.
+func (s *Store) mergeExpiry(tx *bolt.Tx, src *Store, stx *bolt.Tx, bucket, key string) error {
+	from, err := src.expiryID(bucket, key)
+	if err != nil {
+		return err
+	}
+	to, err := s.expiryID(bucket, key)
+	if err != nil {
+		return err
+	}
+	return setExpiry(tx, to, expiryOf(stx, from))
+}
+
+// Replace replaces the whole content of the store, reserved buckets
+// included, with the one of src in a single transaction. If src hides
+// the names, the store must then be unlocked with its key.
+// A src of another format version must be migrated first.
+func (s *Store) Replace(src *Store) error {
+	if err := src.checkVersion(false); err != nil {
+		return err
+	}
+
+	err := src.db.View(func(stx *bolt.Tx) error {
+		return s.db.Update(func(tx *bolt.Tx) error {
+			// buckets cannot be deleted while iterating over them
+			var names [][]byte
+			tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+				names = append(names, append([]byte{}, name...))
+				return nil
+			})
+			for _, el := range names {
+				if err := tx.DeleteBucket(el); err != nil {
+					return err
+				}
+			}
+
+			return stx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				dst, err := tx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(b, dst)
+			})
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	s.version, s.keyPolicy = 0, KeysAsIs
+	return s.db.View(func(tx *bolt.Tx) error {
+		s.hidden, s.nameKey = tx.Bucket([]byte(namesBucket)) != nil, nil
+		return s.loadMeta(tx)
+	})
+}
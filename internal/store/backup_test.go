@@ -0,0 +1,139 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBackupAndMerge(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := New(Options{BucketName: "hosts", Path: filepath.Join(dir, "test.kvs")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Set("web", []byte("10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SetRaw("large", bytes.NewReader(make([]byte, ChunkSize+1)), 0); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Now().Add(time.Hour)
+	if err := db.Expire("large", at); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := db.Backup(&buf); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := filepath.Join(dir, "snapshot.kvs")
+	if err := os.WriteFile(snapshot, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := New(Options{BucketName: "hosts", Path: snapshot})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	dst, err := New(Options{BucketName: "hosts", Path: filepath.Join(dir, "dst.kvs")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	if err := dst.Set("web", []byte("10.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+
+	copied, skipped, err := dst.Merge(src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 1 || skipped != 1 {
+		t.Fatalf("expected 1 copied and 1 skipped, got %d and %d", copied, skipped)
+	}
+	if got, _ := dst.Get("web"); string(got) != "10.0.0.2" {
+		t.Fatalf("expected the existing value to be kept, got '%s'", got)
+	}
+	if info, err := dst.Stat("large"); err != nil || info.Chunks != 2 {
+		t.Fatalf("expected a chunked value, got %+v, %v", info, err)
+	}
+	if got, _ := dst.ExpiresAt("large"); got.UnixMilli() != at.UnixMilli() {
+		t.Fatalf("expected the expiration to be merged, got %v", got)
+	}
+
+	if _, _, err := dst.Merge(src, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := dst.Get("web"); string(got) != "10.0.0.1" {
+		t.Fatalf("expected the value to be replaced, got '%s'", got)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	dir := t.TempDir()
+
+	src, err := New(Options{BucketName: "hosts", Path: filepath.Join(dir, "src.kvs")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	if err := src.Set("web", []byte("10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.SetRaw("large", bytes.NewReader(make([]byte, ChunkSize+1)), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := New(Options{BucketName: "hosts", Path: filepath.Join(dir, "test.kvs")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Set("db", []byte("10.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InBucket("users").Set("admin", []byte("root")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a src of another format version is refused
+	src.version = SchemaVersion + 1
+	if err := db.Replace(src); !errors.Is(err, ErrTooNew) {
+		t.Fatalf("expected ErrTooNew, got %v", err)
+	}
+	if got := db.Keys(); !reflect.DeepEqual(got, []string{"db"}) {
+		t.Fatalf("expected the store to be kept, got %v", got)
+	}
+	src.version = SchemaVersion
+
+	if err := db.Replace(src); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.Keys(); !reflect.DeepEqual(got, []string{"large", "web"}) {
+		t.Fatalf("unexpected keys: %v", got)
+	}
+	if n := db.BucketCount(); n != 1 {
+		t.Fatalf("expected 1 bucket, got %d", n)
+	}
+
+	// the next chunked value must not reuse the ids of the restored ones
+	if _, err := db.SetRaw("other", bytes.NewReader(make([]byte, ChunkSize+1)), 0); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := db.Check(); err != nil || len(report.Problems) != 0 {
+		t.Fatalf("expected no problems, got %v, %v", report.Problems, err)
+	}
+}
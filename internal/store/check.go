@@ -0,0 +1,219 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Problem describes an entry that failed the integrity check;
+// Bucket and Key are empty for the problems of the whole store.
+type Problem struct {
+	Bucket string
+	Key    string
+	Err    error
+}
+
+func (p Problem) String() string {
+	if len(p.Bucket) == 0 {
+		return p.Err.Error()
+	}
+	return fmt.Sprintf("%s/%s: %v", p.Bucket, p.Key, p.Err)
+}
+
+// CheckReport is the result of the integrity check.
+type CheckReport struct {
+	// Keys is the number of checked keys.
+	Keys int
+	// Unverified is the number of encrypted values that
+	// have not been authenticated, since the store has no key.
+	Unverified int
+	// Problems found, if any.
+	Problems []Problem
+}
+
+// Check verifies the consistency of the store file (see bbolt Tx.Check)
+// and that every value can be decoded: chunked values, lists and sets,
+// compressed data and, if the store has the key, encrypted values,
+// which are authenticated. Chunks not referenced by any value are
+// reported as well.
+func (s *Store) Check() (CheckReport, error) {
+	var res CheckReport
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			res.Problems = append(res.Problems, Problem{Err: err})
+		}
+		if len(res.Problems) > 0 {
+			// walking a damaged file could panic
+			return nil
+		}
+
+		used := map[uint64]bool{}
+		err := s.scanTx(tx, func(bucket, key string, v []byte, err error) {
+			res.Keys++
+			if err == nil {
+				err = s.checkValue(v)
+			}
+			if err != nil {
+				res.Problems = append(res.Problems, Problem{bucket, key, err})
+			}
+			if err == nil && IsEncrypted(v) && s.key == nil {
+				res.Unverified++
+			}
+		}, used)
+		if err != nil {
+			return err
+		}
+
+		if n := orphanChunks(tx, used); n > 0 {
+			res.Problems = append(res.Problems, Problem{Err: fmt.Errorf("%d chunks not referenced by any value", n)})
+		}
+		return nil
+	})
+
+	return res, err
+}
+
+// Repair copies all the values that pass the check into a new store
+// at path, which hides the names and normalizes the keys as this store does.
+// It returns the number of copied values.
+func (s *Store) Repair(path string) (int, error) {
+	dst, err := New(Options{Path: path, Key: s.key})
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	if s.hidden {
+		if err := dst.HideNames(); err != nil {
+			return 0, err
+		}
+	}
+	if s.keyPolicy != KeysAsIs {
+		if _, err := dst.MigrateKeys(s.keyPolicy, false); err != nil {
+			return 0, err
+		}
+	}
+
+	var count int
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return dst.db.Update(func(dtx *bolt.Tx) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("kvs: the store file is too damaged to be read: %v", r)
+				}
+			}()
+
+			var werr error
+			err = s.scanTx(tx, func(bucket, key string, v []byte, err error) {
+				if err != nil || werr != nil || s.checkValue(v) != nil {
+					return
+				}
+
+				b, err := dst.createBucket(dtx, bucket)
+				if err == nil {
+					_, err = dst.storeValue(dtx, b, bucket, key, bytes.NewReader(v), 0)
+				}
+				if err != nil {
+					werr = err
+					return
+				}
+				count++
+			}, nil)
+			if err == nil {
+				err = werr
+			}
+			return err
+		})
+	})
+
+	return count, err
+}
+
+// scanTx calls fn for every key of every bucket with the whole raw value,
+// or with the error that prevented reading it, without stopping.
+// The ids of the chunked values are added to used, if not nil.
+func (s *Store) scanTx(tx *bolt.Tx, fn func(bucket, key string, v []byte, err error), used map[uint64]bool) error {
+	return tx.ForEach(func(id []byte, b *bolt.Bucket) error {
+		if isReserved(id) {
+			return nil
+		}
+
+		bucket, err := s.realName(tx, id)
+		if err != nil {
+			fn(string(id), "", nil, fmt.Errorf("bucket name: %w", err))
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+
+			key, err := s.realName(tx, k)
+			if err != nil {
+				fn(bucket, string(k), nil, fmt.Errorf("key name: %w", err))
+				return nil
+			}
+
+			if isManifest(v) {
+				if m, err := decodeManifest(v); err == nil && used != nil {
+					used[m.id] = true
+				}
+			}
+			v, err = loadValue(tx, v)
+			fn(bucket, key, v, err)
+			return nil
+		})
+	})
+}
+
+// checkValue verifies that the raw value can be decoded;
+// the encrypted values are verified only if the store has the key.
+func (s *Store) checkValue(v []byte) error {
+	r, err := s.decoder(bytes.NewReader(v))
+	if err != nil || (IsEncrypted(v) && s.key == nil) {
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	kind := KindOf(v)
+	if v[0]&flagLegacy != 0 {
+		head, _ := br.Peek(len(listHeader))
+		kind = collectionKind(head)
+	}
+	if kind == KindBlob {
+		_, err = io.Copy(io.Discard, br)
+		return err
+	}
+
+	dat, err := io.ReadAll(br)
+	if err == nil && collectionKind(dat) != kind {
+		err = fmt.Errorf("kvs: malformed %s value", kind)
+	}
+	if err == nil {
+		_, err = DecodeCollection(dat)
+	}
+	return err
+}
+
+// orphanChunks returns the number of chunks whose value id is not used.
+func orphanChunks(tx *bolt.Tx, used map[uint64]bool) int {
+	chunks := tx.Bucket([]byte(chunksBucket))
+	if chunks == nil {
+		return 0
+	}
+
+	var res int
+	chunks.ForEach(func(k, _ []byte) error {
+		if len(k) != 12 || !used[binary.BigEndian.Uint64(k)] {
+			res++
+		}
+		return nil
+	})
+	return res
+}
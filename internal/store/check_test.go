@@ -0,0 +1,84 @@
+package store
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestCheckAndRepair(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	db, err := New(Options{BucketName: "data", Path: filepath.Join(dir, "test.kvs"), Key: key, Encrypt: true, Compression: Gzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Set("good", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RPush("list", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SetRaw("large", bytes.NewReader(make([]byte, 2*ChunkSize)), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := db.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Keys != 3 || len(report.Problems) != 0 {
+		t.Fatalf("expected 3 keys and no problems, got %+v", report)
+	}
+
+	// tamper with the encrypted value and drop a chunk of the large one
+	err = db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("data"))
+		v := append([]byte{}, b.Get([]byte("good"))...)
+		v[len(v)-1] ^= 0xff
+		if err := b.Put([]byte("good"), v); err != nil {
+			return err
+		}
+		if err := b.Put([]byte("broken"), append(append([]byte{flagList}, listHeader...), 0x05)); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(chunksBucket)).Delete(chunkKey(1, 1))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = db.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 3 {
+		t.Fatalf("expected 3 problems, got %v", report.Problems)
+	}
+
+	n, err := db.Repair(filepath.Join(dir, "repaired.kvs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 value copied, got %d", n)
+	}
+
+	fixed, err := New(Options{BucketName: "data", Path: filepath.Join(dir, "repaired.kvs"), Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fixed.Close()
+
+	if items, err := fixed.LRange("list", 0, -1); err != nil || len(items) != 1 {
+		t.Fatalf("expected the list to be copied, got %v, %v", items, err)
+	}
+	if report, err := fixed.Check(); err != nil || len(report.Problems) != 0 {
+		t.Fatalf("expected no problems, got %v, %v", report.Problems, err)
+	}
+}
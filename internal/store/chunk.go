@@ -0,0 +1,493 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Raw values up to ChunkSize bytes are stored as they are. The data
+// of the larger ones is split in chunks stored in the reserved chunks
+// bucket, while the key itself holds a small manifest, whose flags
+// are the ones of the value plus flagChunked:
+//
+//	flags | uvarint(size) | uvarint(chunks) | uvarint(id)
+//
+// Each chunk is stored with the key id (8 bytes, big endian) followed
+// by the chunk index (4 bytes, big endian), so that the chunks of the
+// same value are adjacent and sorted.
+// Chunking is transparent: Get, Walk and GetStream always return the
+// whole value.
+
+const (
+	// ChunkSize is the maximum size of a value stored as a single key.
+	ChunkSize = 512 * 1024
+
+	// reservedPrefix marks the buckets used internally by kvs.
+	reservedPrefix = "__kvs_"
+	chunksBucket   = reservedPrefix + "chunks__"
+)
+
+var (
+	// ErrTooLarge is returned when a value exceeds the maximum allowed size
+	ErrTooLarge = errors.New("kvs: value too large")
+)
+
+// Info describes a stored value.
+type Info struct {
+	// Kind of the value, as told by its flags: the values encrypted
+	// by older versions are blobs for the stores without the key.
+	Kind Kind
+	// Size of the raw value in bytes.
+	Size int64
+	// Chunks is the number of chunks the value is split into,
+	// 0 for values stored as a single key.
+	Chunks int
+}
+
+type manifest struct {
+	// flags of the value and its raw size, flags included.
+	flags  byte
+	size   uint64
+	chunks uint64
+	id     uint64
+}
+
+func isReserved(name []byte) bool {
+	return bytes.HasPrefix(name, []byte(reservedPrefix))
+}
+
+// IsReserved reports whether the bucket name is reserved to the store.
+func IsReserved(name string) bool {
+	return isReserved([]byte(name))
+}
+
+func isManifest(v []byte) bool {
+	return len(v) > 0 && v[0]&flagChunked != 0
+}
+
+func (m manifest) encode() []byte {
+	res := []byte{m.flags | flagChunked}
+	res = binary.AppendUvarint(res, m.size)
+	res = binary.AppendUvarint(res, m.chunks)
+	return binary.AppendUvarint(res, m.id)
+}
+
+func decodeManifest(v []byte) (manifest, error) {
+	res := manifest{flags: v[0] &^ flagChunked}
+	if _, err := flagsOf([]byte{res.flags}); err != nil {
+		return res, err
+	}
+
+	buf := v[1:]
+	for _, dst := range []*uint64{&res.size, &res.chunks, &res.id} {
+		x, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return res, fmt.Errorf("kvs: malformed chunked value")
+		}
+		*dst = x
+		buf = buf[n:]
+	}
+
+	return res, nil
+}
+
+func chunkKey(id uint64, idx uint32) []byte {
+	res := make([]byte, 12)
+	binary.BigEndian.PutUint64(res, id)
+	binary.BigEndian.PutUint32(res[8:], idx)
+	return res
+}
+
+// SetStream stores the content read from r for the given key, compressed
+// and encrypted according to the store options, using constant memory.
+// If maxSize is greater than zero and the content exceeds it,
+// nothing is stored and ErrTooLarge is returned.
+func (s *Store) SetStream(k string, r io.Reader, maxSize int64) error {
+	if maxSize > 0 {
+		r = &sizeCapReader{r: r, max: maxSize}
+	}
+
+	if !s.encodes() {
+		_, err := s.SetRaw(k, io.MultiReader(bytes.NewReader([]byte{0}), r), 0)
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := s.encoder(pw)
+		if err == nil {
+			_, err = io.Copy(w, r)
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+
+	_, err := s.SetRaw(k, pr, 0)
+	return err
+}
+
+// GetStream writes the stored value for the given key to w, decrypted
+// (if the store has the key) and decompressed, using constant memory.
+func (s *Store) GetStream(k string, w io.Writer) error {
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := s.GetRaw(k, pw)
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+
+	r, err := s.decoder(pr)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// SetRaw stores the content read from r for the given key, as it is.
+// Values larger than ChunkSize are split in chunks, all written
+// in the same transaction. If maxSize is greater than zero and
+// the content exceeds it, nothing is stored and ErrTooLarge is returned.
+// It returns the number of bytes stored.
+func (s *Store) SetRaw(k string, r io.Reader, maxSize int64) (int64, error) {
+	var size int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := s.createBucket(tx, s.bucketName)
+		if err != nil {
+			return err
+		}
+
+		size, err = s.storeValue(tx, b, s.bucketName, k, r, maxSize)
+		return err
+	})
+
+	return size, err
+}
+
+// storeValue stores the raw value read from r for the key of the bucket b,
+// splitting its data in chunks if larger than ChunkSize.
+func (s *Store) storeValue(tx *bolt.Tx, b *bolt.Bucket, bucket, k string, r io.Reader, maxSize int64) (int64, error) {
+	buf := make([]byte, ChunkSize)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return 0, err
+	}
+	size := int64(n)
+	if maxSize > 0 && size > maxSize {
+		return size, ErrTooLarge
+	}
+	flags, err := flagsOf(buf[:n])
+	if err != nil {
+		return size, err
+	}
+
+	if n < ChunkSize {
+		return size, s.putKey(tx, b, bucket, k, append([]byte{}, buf[:n]...))
+	}
+
+	chunks, err := tx.CreateBucketIfNotExists([]byte(chunksBucket))
+	if err != nil {
+		return size, err
+	}
+
+	id, err := chunks.NextSequence()
+	if err != nil {
+		return size, err
+	}
+
+	// the first chunk holds the data after the flags
+	dat := buf[1:n]
+	var idx uint32
+	for len(dat) > 0 {
+		if err := chunks.Put(chunkKey(id, idx), append([]byte{}, dat...)); err != nil {
+			return size, err
+		}
+		idx++
+
+		n, err = io.ReadFull(r, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return size, err
+		}
+		size += int64(n)
+		if maxSize > 0 && size > maxSize {
+			return size, ErrTooLarge
+		}
+		dat = buf[:n]
+	}
+
+	m := manifest{flags: flags, size: uint64(size), chunks: uint64(idx), id: id}
+	return size, s.putKey(tx, b, bucket, k, m.encode())
+}
+
+// GetRaw writes the value for the given key to w as it is stored,
+// one chunk at a time. It returns the number of bytes written.
+func (s *Store) GetRaw(k string, w io.Writer) (int64, error) {
+	var size int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v, err := s.get(tx, s.bucketName, k)
+		if err != nil {
+			return err
+		}
+
+		size, err = writeValue(tx, v, w)
+		return err
+	})
+
+	return size, err
+}
+
+// Peek returns at most n bytes from the beginning of the raw value stored
+// for the given key, without reading the whole value.
+func (s *Store) Peek(k string, n int) ([]byte, error) {
+	var res []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v, err := s.get(tx, s.bucketName, k)
+		if err != nil {
+			return err
+		}
+
+		if v, err = peekValue(tx, v, n); err != nil {
+			return err
+		}
+		res = append([]byte{}, v...)
+		return nil
+	})
+
+	return res, err
+}
+
+// peekValue returns at most n bytes from the beginning of the raw
+// stored value v, valid only during the transaction if inline.
+func peekValue(tx *bolt.Tx, v []byte, n int) ([]byte, error) {
+	if !isManifest(v) {
+		v, err := inlined(v)
+		if len(v) > n {
+			v = v[:n]
+		}
+		return v, err
+	}
+
+	m, err := decodeManifest(v)
+	if err != nil {
+		return nil, err
+	}
+	chunks := tx.Bucket([]byte(chunksBucket))
+	if chunks == nil {
+		return nil, fmt.Errorf("kvs: missing chunks bucket")
+	}
+	dat := chunks.Get(chunkKey(m.id, 0))
+	if dat == nil {
+		return nil, fmt.Errorf("kvs: missing chunk 0 of value %d", m.id)
+	}
+
+	res := append([]byte{m.flags}, dat...)
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res, nil
+}
+
+// Stat returns the description of the value stored for the given key.
+func (s *Store) Stat(k string) (Info, error) {
+	var res Info
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v, err := s.get(tx, s.bucketName, k)
+		if err != nil {
+			return err
+		}
+
+		if !isManifest(v) {
+			v, err := inlined(v)
+			res = Info{Kind: s.kindOf(v), Size: int64(len(v))}
+			return err
+		}
+
+		m, err := decodeManifest(v)
+		if err != nil {
+			return err
+		}
+		res = Info{Kind: KindOf([]byte{m.flags}), Size: int64(m.size), Chunks: int(m.chunks)}
+		return nil
+	})
+
+	return res, err
+}
+
+// inlined returns the stored value v, checking that it is a raw value.
+func inlined(v []byte) ([]byte, error) {
+	if _, err := flagsOf(v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// writeValue writes the raw stored value v to w,
+// reassembling its chunks if it is a manifest.
+func writeValue(tx *bolt.Tx, v []byte, w io.Writer) (int64, error) {
+	if !isManifest(v) {
+		dat, err := inlined(v)
+		if err != nil {
+			return 0, err
+		}
+		n, err := w.Write(dat)
+		return int64(n), err
+	}
+
+	m, err := decodeManifest(v)
+	if err != nil {
+		return 0, err
+	}
+
+	chunks := tx.Bucket([]byte(chunksBucket))
+	if chunks == nil {
+		return 0, fmt.Errorf("kvs: missing chunks bucket")
+	}
+
+	n, err := w.Write([]byte{m.flags})
+	size := int64(n)
+	if err != nil {
+		return size, err
+	}
+	for idx := uint32(0); uint64(idx) < m.chunks; idx++ {
+		dat := chunks.Get(chunkKey(m.id, idx))
+		if dat == nil {
+			return size, fmt.Errorf("kvs: missing chunk %d of value %d", idx, m.id)
+		}
+
+		n, err := w.Write(dat)
+		size += int64(n)
+		if err != nil {
+			return size, err
+		}
+	}
+
+	if uint64(size) != m.size {
+		return size, fmt.Errorf("kvs: chunked value size mismatch")
+	}
+
+	return size, nil
+}
+
+// getValue returns a copy of the value stored for the given key,
+// reassembling its chunks if needed. It returns nil if the key does not exist.
+func getValue(tx *bolt.Tx, b *bolt.Bucket, k []byte) ([]byte, error) {
+	v := b.Get(k)
+	if v == nil {
+		return nil, nil
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(v)))
+	if _, err := writeValue(tx, v, buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// loadValue returns the raw stored value v, reassembling its chunks
+// if needed; an inline value is only valid during the transaction.
+func loadValue(tx *bolt.Tx, v []byte) ([]byte, error) {
+	if !isManifest(v) {
+		return inlined(v)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, ChunkSize))
+	if _, err := writeValue(tx, v, buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// putValue stores the value for the given key,
+// releasing the chunks of the previous value, if any.
+func putValue(tx *bolt.Tx, b *bolt.Bucket, k, v []byte) error {
+	if err := deleteChunks(tx, b.Get(k)); err != nil {
+		return err
+	}
+	return b.Put(k, v)
+}
+
+// deleteValue deletes the value stored for the given key and all its chunks.
+func deleteValue(tx *bolt.Tx, b *bolt.Bucket, k []byte) error {
+	if err := deleteChunks(tx, b.Get(k)); err != nil {
+		return err
+	}
+	return b.Delete(k)
+}
+
+// deleteBucketChunks deletes the chunks of all the values of the bucket.
+func deleteBucketChunks(tx *bolt.Tx, b *bolt.Bucket) error {
+	return b.ForEach(func(_, v []byte) error {
+		return deleteChunks(tx, v)
+	})
+}
+
+func deleteChunks(tx *bolt.Tx, v []byte) error {
+	if !isManifest(v) {
+		return nil
+	}
+
+	m, err := decodeManifest(v)
+	if err != nil {
+		return err
+	}
+
+	chunks := tx.Bucket([]byte(chunksBucket))
+	if chunks == nil {
+		return nil
+	}
+
+	for idx := uint32(0); uint64(idx) < m.chunks; idx++ {
+		if err := chunks.Delete(chunkKey(m.id, idx)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cloneChunks duplicates the chunks of a manifest value
+// and returns the manifest pointing to the new copies;
+// any other stored value is just copied.
+func cloneChunks(tx *bolt.Tx, v []byte) ([]byte, error) {
+	if !isManifest(v) {
+		return append([]byte{}, v...), nil
+	}
+
+	m, err := decodeManifest(v)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := tx.Bucket([]byte(chunksBucket))
+	if chunks == nil {
+		return nil, fmt.Errorf("kvs: missing chunks bucket")
+	}
+
+	id, err := chunks.NextSequence()
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := uint32(0); uint64(idx) < m.chunks; idx++ {
+		dat := chunks.Get(chunkKey(m.id, idx))
+		if dat == nil {
+			return nil, fmt.Errorf("kvs: missing chunk %d of value %d", idx, m.id)
+		}
+		if err := chunks.Put(chunkKey(id, idx), append([]byte{}, dat...)); err != nil {
+			return nil, err
+		}
+	}
+
+	m.id = id
+	return m.encode(), nil
+}
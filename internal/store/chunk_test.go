@@ -0,0 +1,110 @@
+package store
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestChunkedValue(t *testing.T) {
+	db := newTestStore(t, "files")
+
+	src := make([]byte, 2*ChunkSize+123)
+	if _, err := rand.Read(src); err != nil {
+		t.Fatal(err)
+	}
+	// the flags of a plain value
+	src[0] = 0
+
+	if err := db.SetStream("big", bytes.NewReader(src), int64(len(src)-1)); err != ErrTooLarge {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+	if v, _ := db.Get("big"); v != nil {
+		t.Fatal("nothing should be stored when the value is too large")
+	}
+
+	n, err := db.SetRaw("big", bytes.NewReader(src), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(src)) {
+		t.Fatalf("expected %d bytes, got %d", len(src), n)
+	}
+
+	info, err := db.Stat("big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(src)) || info.Chunks != 3 {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+
+	var buf bytes.Buffer
+	if err := db.GetStream("big", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), src[1:]) {
+		t.Fatal("streamed value does not match")
+	}
+
+	if err := db.Copy("files", "big", "files", "copy", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("big"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.Get("copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, src[1:]) {
+		t.Fatal("copied value does not match")
+	}
+
+	if err := db.Set("copy", []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.Buckets(); len(got) != 1 || got[0] != "files" {
+		t.Fatalf("unexpected buckets: %v", got)
+	}
+
+	chunks := &Store{db: db.db, bucketName: chunksBucket}
+	if keys := chunks.Keys(); len(keys) != 0 {
+		t.Fatalf("expected all chunks to be released, found %d", len(keys))
+	}
+}
+
+func TestHeaderLookalike(t *testing.T) {
+	db := newTestStore(t, "files")
+
+	// values that look like the headers of the encoded data
+	// or like the manifests of older versions
+	for _, h := range []string{"c", "z", "E", "l", "s"} {
+		src := []byte("\x00KV" + h + "\x05\x01\x01")
+		if err := db.Set(h, src); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := db.Get(h); err != nil || !bytes.Equal(got, src) {
+			t.Fatalf("expected %v, got %v, %v", src, got, err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := db.GetRaw(h, &buf); err != nil {
+			t.Fatal(err)
+		}
+		raw := buf.Bytes()
+		if KindOf(raw) != KindBlob || IsEncrypted(raw) || IsCompressed(raw) {
+			t.Fatalf("expected a plain blob, got %v", raw)
+		}
+
+		info, err := db.Stat(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Kind != KindBlob || info.Size != int64(len(src)+1) || info.Chunks != 0 {
+			t.Fatalf("unexpected info: %+v", info)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lucasepe/kvs/internal/aes"
+)
+
+// Every raw value, as stored and as read and written by GetRaw and
+// SetRaw, starts with a byte of flags telling how the data that follows
+// has been encoded. Values written by Set and SetStream are eventually
+// compressed and then encrypted, according to the store options:
+//
+//	flags (1 byte) | data
+//
+//	compressed: 0x00 'K' 'V' 'z' | algorithm (1 byte) | compressed data
+//	encrypted:  0x00 'K' 'V' 'E' | ... (see the aes streaming format)
+//
+// Only the flags tell how a value is decoded, the headers of the data
+// just guard against malformed values: an encrypted value can hold a
+// compressed one. Get and GetStream reverse the process, while GetRaw
+// and SetRaw access the values as they are stored.
+const (
+	// flagChunked marks the stored values split in chunks (see chunk.go).
+	flagChunked byte = 1 << iota
+	flagEncrypted
+	flagCompressed
+	// flagList and flagSet mark the collections (see collection.go).
+	flagList
+	flagSet
+	// flagLegacy marks the values encrypted before the flags were
+	// recorded (see markValues): their compression and kind are
+	// found once decrypted, until Rekey records them.
+	flagLegacy
+
+	knownFlags = flagLegacy<<1 - 1
+)
+
+// Compression is the algorithm used to compress the values.
+type Compression byte
+
+const (
+	// NoCompression stores the values as they are.
+	NoCompression Compression = iota
+	// Gzip compresses the values using gzip.
+	Gzip
+)
+
+func (c Compression) String() string {
+	switch c {
+	case Gzip:
+		return "gzip"
+	default:
+		return "none"
+	}
+}
+
+// ParseCompression returns the compression algorithm with the specified name.
+func ParseCompression(name string) (Compression, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return NoCompression, nil
+	case "gzip":
+		return Gzip, nil
+	default:
+		return NoCompression, fmt.Errorf("kvs: unsupported compression: %s", name)
+	}
+}
+
+var compressHeader = []byte{0x00, 'K', 'V', 'z'}
+
+var (
+	// ErrKeyRequired is returned when writing an encrypted value
+	// to a store opened without the encryption key
+	ErrKeyRequired = errors.New("kvs: the encryption key is required")
+
+	errMalformed = errors.New("kvs: malformed value")
+)
+
+// flagsOf returns the flags of the raw value v.
+func flagsOf(v []byte) (byte, error) {
+	if len(v) == 0 || v[0]&^knownFlags != 0 || v[0]&flagChunked != 0 ||
+		v[0]&(flagList|flagSet) == flagList|flagSet {
+		return 0, errMalformed
+	}
+	return v[0], nil
+}
+
+// IsEncrypted reports whether the raw value is encrypted.
+func IsEncrypted(v []byte) bool {
+	return len(v) > 0 && v[0]&flagEncrypted != 0
+}
+
+// IsCompressed reports whether the raw value is compressed; the
+// compression of the values encrypted by older versions is unknown.
+func IsCompressed(v []byte) bool {
+	return len(v) > 0 && v[0]&flagCompressed != 0
+}
+
+// Encoding describes how a raw value has been encoded.
+type Encoding struct {
+	Encrypted   bool
+	Compression Compression
+}
+
+// EncodingOf returns how the raw value has been encoded; the compression
+// of an encrypted value is detected only if the store has the key.
+func (s *Store) EncodingOf(v []byte) (Encoding, error) {
+	_, res, err := newDecoder(bytes.NewReader(v), s.key)
+	return res, err
+}
+
+// encodes reports whether the values are transformed before being stored.
+func (s *Store) encodes() bool {
+	return s.encrypt || s.compression != NoCompression
+}
+
+// encoder returns a writer that compresses and encrypts, according
+// to the store options, the data written to w. Close must be called
+// to flush all the data; it does not close w.
+func (s *Store) encoder(w io.Writer) (io.WriteCloser, error) {
+	var key []byte
+	if s.encrypt {
+		if s.key == nil {
+			return nil, ErrKeyRequired
+		}
+		key = s.key
+	}
+
+	return NewEncoder(w, key, s.compression)
+}
+
+// NewEncoder returns a writer that compresses and then, if key is not nil,
+// encrypts the data written to w, producing a raw value as stored by Set.
+// Close must be called to flush all the data; it does not close w.
+func NewEncoder(w io.Writer, key []byte, c Compression) (io.WriteCloser, error) {
+	return newEncoder(w, key, c, KindBlob)
+}
+
+// newEncoder is NewEncoder for a value of the given kind.
+func newEncoder(w io.Writer, key []byte, c Compression, kind Kind) (io.WriteCloser, error) {
+	flags := kindFlags(kind)
+	if key != nil {
+		flags |= flagEncrypted
+	}
+	if c != NoCompression {
+		flags |= flagCompressed
+	}
+	if _, err := w.Write([]byte{flags}); err != nil {
+		return nil, err
+	}
+
+	var closers []io.Closer
+
+	if key != nil {
+		enc, err := aes.NewWriter(w, key)
+		if err != nil {
+			return nil, err
+		}
+		w = enc
+		closers = append(closers, enc)
+	}
+
+	switch c {
+	case NoCompression:
+	case Gzip:
+		if _, err := w.Write(append(append([]byte{}, compressHeader...), byte(Gzip))); err != nil {
+			return nil, err
+		}
+
+		zw := gzip.NewWriter(w)
+		w = zw
+		closers = append(closers, zw)
+	default:
+		return nil, fmt.Errorf("kvs: unsupported compression: %d", c)
+	}
+
+	return &chainWriter{Writer: w, closers: closers}, nil
+}
+
+// decoder returns a reader that decrypts (if the store has the key)
+// and decompresses the raw value read from r.
+// Encrypted values are returned as they are if the store has no key.
+func (s *Store) decoder(r io.Reader) (io.Reader, error) {
+	return NewDecoder(r, s.key)
+}
+
+// NewDecoder returns a reader that decrypts (if key is not nil)
+// and decompresses the raw value read from r.
+// Encrypted values are returned as they are if key is nil.
+func NewDecoder(r io.Reader, key []byte) (io.Reader, error) {
+	res, _, err := newDecoder(r, key)
+	return res, err
+}
+
+// newDecoder is NewDecoder, returning the encoding of the value as well.
+func newDecoder(r io.Reader, key []byte) (io.Reader, Encoding, error) {
+	var enc Encoding
+
+	br := bufio.NewReader(r)
+	head, err := br.Peek(1)
+	if len(head) == 0 {
+		if err == io.EOF {
+			err = errMalformed
+		}
+		return nil, enc, err
+	}
+	flags, err := flagsOf(head)
+	if err != nil {
+		return nil, enc, err
+	}
+
+	enc.Encrypted = flags&flagEncrypted != 0
+	if enc.Encrypted && key == nil {
+		return br, enc, nil
+	}
+	br.Discard(1)
+
+	if enc.Encrypted {
+		dec, err := aes.NewReader(br, key)
+		if err != nil {
+			return nil, enc, err
+		}
+		br = bufio.NewReader(dec)
+	}
+
+	if enc.Compression, err = compressionOf(br, flags); err != nil {
+		return nil, enc, err
+	}
+	switch enc.Compression {
+	case NoCompression:
+		return br, enc, nil
+	case Gzip:
+		br.Discard(len(compressHeader) + 1)
+		zr, err := gzip.NewReader(br)
+		return zr, enc, err
+	default:
+		return nil, enc, fmt.Errorf("kvs: unsupported compression: %d", enc.Compression)
+	}
+}
+
+// compressionOf returns the compression algorithm of the data read from br,
+// as told by the flags; the data of the values encrypted by older versions
+// is compressed only if it starts with the compression header.
+func compressionOf(br *bufio.Reader, flags byte) (Compression, error) {
+	if flags&(flagCompressed|flagLegacy) == 0 {
+		return NoCompression, nil
+	}
+
+	head, _ := br.Peek(len(compressHeader) + 1)
+	if len(head) <= len(compressHeader) || !bytes.HasPrefix(head, compressHeader) {
+		if flags&flagLegacy != 0 {
+			return NoCompression, nil
+		}
+		return NoCompression, errMalformed
+	}
+	return Compression(head[len(compressHeader)]), nil
+}
+
+// Encode compresses and encrypts the value according to the store options.
+func (s *Store) Encode(v []byte) ([]byte, error) {
+	return s.encodeAs(v, Encoding{Encrypted: s.encrypt, Compression: s.compression}, KindBlob)
+}
+
+// encodeAs returns the raw value of kind holding v,
+// compressed and encrypted as described by enc.
+func (s *Store) encodeAs(v []byte, enc Encoding, kind Kind) ([]byte, error) {
+	var key []byte
+	if enc.Encrypted {
+		if s.key == nil {
+			return nil, ErrKeyRequired
+		}
+		key = s.key
+	}
+
+	var buf bytes.Buffer
+	w, err := newEncoder(&buf, key, enc.Compression, kind)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(v); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decode decrypts and decompresses a raw value, as stored.
+// Encrypted values are returned as they are if the store has no key.
+func (s *Store) Decode(v []byte) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	flags, err := flagsOf(v)
+	if err != nil {
+		return nil, err
+	}
+	if flags&(flagEncrypted|flagCompressed|flagLegacy) == 0 {
+		return v[1:], nil
+	}
+
+	r, err := s.decoder(bytes.NewReader(v))
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(r)
+}
+
+type chainWriter struct {
+	io.Writer
+	closers []io.Closer
+}
+
+// Close closes the writers from the outermost to the innermost.
+func (cw *chainWriter) Close() error {
+	for i := len(cw.closers) - 1; i >= 0; i-- {
+		if err := cw.closers[i].Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sizeCapReader fails with ErrTooLarge as soon as
+// more than max bytes have been read from r.
+type sizeCapReader struct {
+	r   io.Reader
+	max int64
+	n   int64
+}
+
+func (cr *sizeCapReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	if cr.n > cr.max {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
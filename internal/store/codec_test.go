@@ -0,0 +1,138 @@
+package store
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/lucasepe/kvs/internal/pbdk"
+)
+
+func TestCodec(t *testing.T) {
+	key, err := pbdk.DeriveKey([]byte("abbracadabbra!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := bytes.Repeat([]byte(`{"host": "example.com", "port": 443}`), 1000)
+
+	tests := []struct {
+		name        string
+		encrypt     bool
+		compression Compression
+	}{
+		{"plain", false, NoCompression},
+		{"gzip", false, Gzip},
+		{"encrypted", true, NoCompression},
+		{"gzip+encrypted", true, Gzip},
+	}
+
+	path := filepath.Join(t.TempDir(), "test.kvs")
+	for _, tc := range tests {
+		db, err := New(Options{
+			BucketName:  "config",
+			Path:        path,
+			Key:         key,
+			Encrypt:     tc.encrypt,
+			Compression: tc.compression,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.Set(tc.name, src); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		var raw bytes.Buffer
+		if _, err := db.GetRaw(tc.name, &raw); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if IsEncrypted(raw.Bytes()) != tc.encrypt {
+			t.Fatalf("%s: unexpected encryption", tc.name)
+		}
+		if !tc.encrypt && IsCompressed(raw.Bytes()) != (tc.compression != NoCompression) {
+			t.Fatalf("%s: unexpected compression", tc.name)
+		}
+		if tc.compression != NoCompression && raw.Len() >= len(src) {
+			t.Fatalf("%s: value not compressed (%d bytes)", tc.name, raw.Len())
+		}
+
+		got, err := db.Get(tc.name)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !bytes.Equal(got, src) {
+			t.Fatalf("%s: decoded value does not match", tc.name)
+		}
+
+		var buf bytes.Buffer
+		if err := db.GetStream(tc.name, &buf); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !bytes.Equal(buf.Bytes(), src) {
+			t.Fatalf("%s: streamed value does not match", tc.name)
+		}
+
+		db.Close()
+	}
+
+	db, err := New(Options{BucketName: "config", Path: path, Encrypt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Set("nokey", src); err != ErrKeyRequired {
+		t.Fatalf("expected ErrKeyRequired, got %v", err)
+	}
+
+	// without the key encrypted values are returned as they are
+	got, err := db.Get("encrypted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(got) {
+		t.Fatal("expected the raw ciphertext")
+	}
+}
+
+func TestStats(t *testing.T) {
+	key, _ := pbdk.DeriveKey([]byte("s1mSal5Bim$$"))
+
+	db, err := New(Options{
+		BucketName:  "config",
+		Path:        filepath.Join(t.TempDir(), "test.kvs"),
+		Key:         key,
+		Compression: Gzip,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	src := bytes.Repeat([]byte("-----BEGIN CERTIFICATE-----\n"), 100)
+	db.Set("cert", src)
+
+	db.encrypt = true
+	db.Set("secret", src)
+
+	res, err := db.Stats("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 {
+		t.Fatalf("expected 1 bucket, got %d", len(res))
+	}
+
+	st := res[0]
+	if st.Keys != 2 || st.Compressed != 2 || st.Encrypted != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	if st.RawSize != int64(2*len(src)) {
+		t.Fatalf("expected raw size %d, got %d", 2*len(src), st.RawSize)
+	}
+	if st.Size >= st.RawSize {
+		t.Fatalf("expected compressed size < raw size: %+v", st)
+	}
+}
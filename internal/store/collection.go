@@ -0,0 +1,405 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Lists and sets are stored as values marked by flagList or flagSet,
+// whose data is made of a 4 bytes header followed by the elements,
+// each one prefixed by its length encoded as an unsigned varint:
+//
+//	0x00 'K' 'V' 'l' | uvarint(len(e1)) e1 | uvarint(len(e2)) e2 | ...   (list)
+//	0x00 'K' 'V' 's' | uvarint(len(m1)) m1 | uvarint(len(m2)) m2 | ...   (set)
+//
+// Set members are always kept sorted and unique.
+// Any other value is an opaque blob, whatever its data.
+
+// Kind is the type of a stored value.
+type Kind int
+
+const (
+	// KindBlob is an opaque value.
+	KindBlob Kind = iota
+	// KindList is an ordered list of elements.
+	KindList
+	// KindSet is a sorted set of unique members.
+	KindSet
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindList:
+		return "list"
+	case KindSet:
+		return "set"
+	default:
+		return "blob"
+	}
+}
+
+var (
+	listHeader = []byte{0x00, 'K', 'V', 'l'}
+	setHeader  = []byte{0x00, 'K', 'V', 's'}
+)
+
+var (
+	// ErrWrongType is returned when a list or set operation is
+	// applied to a value of a different kind
+	ErrWrongType = errors.New("kvs: operation against a value holding the wrong kind of value")
+)
+
+// KindOf returns the kind of the raw value v, as told by its flags:
+// the values encrypted by older versions are blobs (see Store.Stat).
+func KindOf(v []byte) Kind {
+	switch {
+	case len(v) == 0:
+		return KindBlob
+	case v[0]&flagList != 0:
+		return KindList
+	case v[0]&flagSet != 0:
+		return KindSet
+	default:
+		return KindBlob
+	}
+}
+
+// kindFlags returns the flag marking the values of the kind.
+func kindFlags(kind Kind) byte {
+	switch kind {
+	case KindList:
+		return flagList
+	case KindSet:
+		return flagSet
+	default:
+		return 0
+	}
+}
+
+// kindOf returns the kind of the raw value v: the values encrypted
+// by older versions are decrypted, if the store has the key, to find it.
+func (s *Store) kindOf(v []byte) Kind {
+	if len(v) == 0 || v[0]&flagLegacy == 0 || s.key == nil {
+		return KindOf(v)
+	}
+
+	r, err := s.decoder(bytes.NewReader(v))
+	if err != nil {
+		return KindBlob
+	}
+	head := make([]byte, len(listHeader))
+	n, _ := io.ReadFull(r, head)
+	return collectionKind(head[:n])
+}
+
+// collectionKind returns the kind of the decoded data
+// of a collection, looking at its header.
+func collectionKind(dat []byte) Kind {
+	switch {
+	case bytes.HasPrefix(dat, listHeader):
+		return KindList
+	case bytes.HasPrefix(dat, setHeader):
+		return KindSet
+	default:
+		return KindBlob
+	}
+}
+
+// DecodeCollection returns the elements of a list or set value, once decoded.
+func DecodeCollection(v []byte) ([][]byte, error) {
+	kind := collectionKind(v)
+	if kind == KindBlob {
+		return nil, ErrWrongType
+	}
+
+	var res [][]byte
+	buf := v[len(listHeader):]
+	for len(buf) > 0 {
+		l, n := binary.Uvarint(buf)
+		if n <= 0 || uint64(len(buf)-n) < l {
+			return nil, fmt.Errorf("kvs: malformed %s value", kind)
+		}
+		buf = buf[n:]
+
+		el := make([]byte, l)
+		copy(el, buf[:l])
+		res = append(res, el)
+		buf = buf[l:]
+	}
+
+	return res, nil
+}
+
+func encodeCollection(kind Kind, items [][]byte) []byte {
+	size := len(listHeader)
+	for _, el := range items {
+		size += binary.MaxVarintLen64 + len(el)
+	}
+
+	res := make([]byte, 0, size)
+	if kind == KindSet {
+		res = append(res, setHeader...)
+	} else {
+		res = append(res, listHeader...)
+	}
+
+	for _, el := range items {
+		res = binary.AppendUvarint(res, uint64(len(el)))
+		res = append(res, el...)
+	}
+
+	return res
+}
+
+// LPush inserts all the specified values at the head of the list stored at key.
+// Values are inserted one after the other, so the last one ends up first.
+// It returns the length of the list after the push operation.
+func (s *Store) LPush(k string, vals ...[]byte) (int, error) {
+	var size int
+	err := s.updateCollection(k, KindList, func(items [][]byte) [][]byte {
+		res := make([][]byte, 0, len(vals)+len(items))
+		for i := len(vals) - 1; i >= 0; i-- {
+			res = append(res, vals[i])
+		}
+		res = append(res, items...)
+		size = len(res)
+		return res
+	})
+	return size, err
+}
+
+// RPush appends all the specified values at the tail of the list stored at key.
+// It returns the length of the list after the push operation.
+func (s *Store) RPush(k string, vals ...[]byte) (int, error) {
+	var size int
+	err := s.updateCollection(k, KindList, func(items [][]byte) [][]byte {
+		items = append(items, vals...)
+		size = len(items)
+		return items
+	})
+	return size, err
+}
+
+// LPop removes and returns the first element of the list stored at key.
+// It returns nil when the list is empty or does not exist.
+// The key is deleted once the last element has been removed.
+func (s *Store) LPop(k string) ([]byte, error) {
+	var res []byte
+	err := s.updateCollection(k, KindList, func(items [][]byte) [][]byte {
+		if len(items) == 0 {
+			return items
+		}
+		res = items[0]
+		return items[1:]
+	})
+	return res, err
+}
+
+// LRange returns the elements of the list stored at key between
+// start and stop (both inclusive). Negative offsets are counted
+// from the end of the list, so -1 is the last element.
+func (s *Store) LRange(k string, start, stop int) ([][]byte, error) {
+	items, err := s.collection(k, KindList)
+	if err != nil {
+		return nil, err
+	}
+
+	size := len(items)
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	if start > stop {
+		return [][]byte{}, nil
+	}
+
+	return items[start : stop+1], nil
+}
+
+// SAdd adds the specified members to the set stored at key.
+// It returns the number of members that were not already in the set.
+func (s *Store) SAdd(k string, members ...[]byte) (int, error) {
+	var added int
+	err := s.updateCollection(k, KindSet, func(items [][]byte) [][]byte {
+		for _, m := range members {
+			i, found := searchMember(items, m)
+			if found {
+				continue
+			}
+			items = append(items, nil)
+			copy(items[i+1:], items[i:])
+			items[i] = m
+			added++
+		}
+		return items
+	})
+	return added, err
+}
+
+// SRem removes the specified members from the set stored at key.
+// It returns the number of members that were actually removed.
+// The key is deleted once the last member has been removed.
+func (s *Store) SRem(k string, members ...[]byte) (int, error) {
+	var removed int
+	err := s.updateCollection(k, KindSet, func(items [][]byte) [][]byte {
+		for _, m := range members {
+			i, found := searchMember(items, m)
+			if !found {
+				continue
+			}
+			items = append(items[:i], items[i+1:]...)
+			removed++
+		}
+		return items
+	})
+	return removed, err
+}
+
+// SMembers returns all the members of the set stored at key.
+func (s *Store) SMembers(k string) ([][]byte, error) {
+	return s.collection(k, KindSet)
+}
+
+// SIsMember reports whether member belongs to the set stored at key.
+func (s *Store) SIsMember(k string, member []byte) (bool, error) {
+	items, err := s.collection(k, KindSet)
+	if err != nil {
+		return false, err
+	}
+
+	_, found := searchMember(items, member)
+	return found, nil
+}
+
+func searchMember(items [][]byte, m []byte) (int, bool) {
+	i := sort.Search(len(items), func(i int) bool {
+		return bytes.Compare(items[i], m) >= 0
+	})
+	return i, i < len(items) && bytes.Equal(items[i], m)
+}
+
+// collection returns the elements of the list or set stored at key.
+// A missing bucket or key is treated as an empty collection.
+func (s *Store) collection(k string, kind Kind) ([][]byte, error) {
+	var res [][]byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v, err := s.get(tx, s.bucketName, k)
+		if err == ErrBucketNotFound || err == ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		res, err = s.decodeKind(tx, v, kind)
+		return err
+	})
+
+	return res, err
+}
+
+// updateCollection applies fn to the elements of the list or set
+// stored at key within a single read-write transaction.
+func (s *Store) updateCollection(k string, kind Kind, fn func([][]byte) [][]byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var items [][]byte
+		enc := Encoding{Encrypted: s.encrypt, Compression: s.compression}
+		v, err := s.get(tx, s.bucketName, k)
+		switch err {
+		case nil:
+			if items, err = s.decodeKind(tx, v, kind); err != nil {
+				return err
+			}
+			// the collection keeps its encryption and compression
+			if enc, err = s.keepEncoding(tx, v, enc); err != nil {
+				return err
+			}
+		case ErrBucketNotFound, ErrKeyNotFound:
+		default:
+			return err
+		}
+
+		items = fn(items)
+		if len(items) == 0 {
+			if v == nil {
+				return nil
+			}
+			b, err := s.bucket(tx, s.bucketName)
+			if err != nil {
+				return err
+			}
+			return s.deleteKey(tx, b, s.bucketName, k)
+		}
+
+		b, err := s.createBucket(tx, s.bucketName)
+		if err != nil {
+			return err
+		}
+		dat, err := s.encodeAs(encodeCollection(kind, items), enc, kind)
+		if err != nil {
+			return err
+		}
+		return s.putKey(tx, b, s.bucketName, k, dat)
+	})
+}
+
+// keepEncoding returns the encoding enc, upgraded with the
+// encryption and compression of the stored value v.
+func (s *Store) keepEncoding(tx *bolt.Tx, v []byte, enc Encoding) (Encoding, error) {
+	dat, err := loadValue(tx, v)
+	if err != nil {
+		return enc, err
+	}
+	cur, err := s.EncodingOf(dat)
+	if err != nil {
+		return enc, err
+	}
+
+	enc.Encrypted = enc.Encrypted || cur.Encrypted
+	if cur.Compression != NoCompression {
+		enc.Compression = cur.Compression
+	}
+	return enc, nil
+}
+
+// decodeKind returns the elements of the stored value v, decrypted and
+// decompressed, that must be a collection of the given kind.
+func (s *Store) decodeKind(tx *bolt.Tx, v []byte, kind Kind) ([][]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	v, err := loadValue(tx, v)
+	if err != nil {
+		return nil, err
+	}
+	if IsEncrypted(v) && s.key == nil {
+		return nil, ErrKeyRequired
+	}
+	if s.kindOf(v) != kind {
+		return nil, ErrWrongType
+	}
+
+	dat, err := s.Decode(v)
+	if err != nil {
+		return nil, err
+	}
+	if collectionKind(dat) != kind {
+		return nil, fmt.Errorf("kvs: malformed %s value", kind)
+	}
+	return DecodeCollection(dat)
+}
@@ -0,0 +1,243 @@
+package store
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestStore(t *testing.T, bucket string) *Store {
+	t.Helper()
+
+	db, err := New(Options{
+		BucketName: bucket,
+		Path:       filepath.Join(t.TempDir(), "test.kvs"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func toStrings(items [][]byte) []string {
+	res := make([]string, len(items))
+	for i, el := range items {
+		res[i] = string(el)
+	}
+	return res
+}
+
+func TestList(t *testing.T) {
+	db := newTestStore(t, "hosts")
+
+	if _, err := db.RPush("web", []byte("b"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	n, err := db.LPush("web", []byte("a"), []byte("z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Fatalf("expected length 4, got %d", n)
+	}
+
+	tests := []struct {
+		start, stop int
+		want        []string
+	}{
+		{0, -1, []string{"z", "a", "b", "c"}},
+		{1, 2, []string{"a", "b"}},
+		{-2, -1, []string{"b", "c"}},
+		{2, 100, []string{"b", "c"}},
+		{3, 1, []string{}},
+	}
+
+	for _, tc := range tests {
+		got, err := db.LRange("web", tc.start, tc.stop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(toStrings(got), tc.want) {
+			t.Fatalf("LRange(%d, %d): want %v, got %v", tc.start, tc.stop, tc.want, toStrings(got))
+		}
+	}
+
+	for _, want := range []string{"z", "a", "b", "c"} {
+		got, err := db.LPop("web")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("want: %q, got: %q", want, got)
+		}
+	}
+
+	if v, _ := db.Get("web"); v != nil {
+		t.Fatalf("expected the empty list to be deleted, got %q", v)
+	}
+}
+
+func TestSet(t *testing.T) {
+	db := newTestStore(t, "users")
+
+	n, err := db.SAdd("admins", []byte("bob"), []byte("alice"), []byte("bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 added members, got %d", n)
+	}
+
+	got, err := db.SMembers("admins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"alice", "bob"}; !reflect.DeepEqual(toStrings(got), want) {
+		t.Fatalf("want: %v, got: %v", want, toStrings(got))
+	}
+
+	ok, err := db.SIsMember("admins", []byte("alice"))
+	if err != nil || !ok {
+		t.Fatalf("expected alice to be a member (err: %v)", err)
+	}
+
+	n, err = db.SRem("admins", []byte("alice"), []byte("carol"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 removed member, got %d", n)
+	}
+
+	ok, _ = db.SIsMember("admins", []byte("alice"))
+	if ok {
+		t.Fatal("alice should not be a member anymore")
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	db := newTestStore(t, "misc")
+
+	if err := db.Set("plain", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RPush("plain", []byte("x")); err != ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+
+	if _, err := db.SAdd("tags", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LRange("tags", 0, -1); err != ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := db.GetRaw("tags", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if KindOf(buf.Bytes()) != KindSet {
+		t.Fatalf("expected a set, got %s", KindOf(buf.Bytes()))
+	}
+}
+
+func TestEncryptedCollection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.kvs")
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	db, err := New(Options{BucketName: "hosts", Path: path, Key: key, Encrypt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.RPush("web", []byte("10.0.0.1"), []byte("10.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SAdd("tags", []byte("primary")); err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Walk("", func(_, key string, v []byte) error {
+		if !IsEncrypted(v) {
+			t.Errorf("expected '%s' to be encrypted", key)
+		}
+		for _, el := range []string{"10.0.0.1", "10.0.0.2", "primary"} {
+			if bytes.Contains(v, []byte(el)) {
+				t.Errorf("'%s' holds '%s' in plaintext", key, el)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := db.LRange("web", 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := toStrings(items); !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Fatalf("unexpected list: %v", got)
+	}
+	if info, err := db.Stat("tags"); err != nil || info.Kind != KindSet {
+		t.Fatalf("expected a set, got %+v, %v", info, err)
+	}
+
+	// without the key the elements cannot be read
+	ro := &Store{db: db.db, bucketName: "hosts"}
+	if _, err := ro.SMembers("tags"); err != ErrKeyRequired {
+		t.Fatalf("expected ErrKeyRequired, got %v", err)
+	}
+}
+
+func TestCollectionKeepsEncoding(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.kvs")
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	db, err := New(Options{BucketName: "hosts", Path: path, Key: key, Encrypt: true, Compression: Gzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RPush("web", []byte("10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// a handle with the key, but not encrypting
+	db, err = New(Options{BucketName: "hosts", Path: path, Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.RPush("web", []byte("10.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := db.GetRaw("web", &buf); err != nil {
+		t.Fatal(err)
+	}
+	enc, err := db.EncodingOf(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !enc.Encrypted || enc.Compression != Gzip {
+		t.Fatalf("expected the list to stay encrypted and compressed, got %+v", enc)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("10.0.0.2")) {
+		t.Fatal("the pushed element is stored in plaintext")
+	}
+
+	items, err := db.LRange("web", 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := toStrings(items); !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Fatalf("unexpected list: %v", got)
+	}
+}
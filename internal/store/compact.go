@@ -0,0 +1,107 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// compactTxMaxSize limits the size of the transactions used to
+	// copy the values into the compacted file.
+	compactTxMaxSize = 64 * 1024 * 1024
+	// minCompactFree is the minimum free space in the file
+	// worth an auto-compaction.
+	minCompactFree = 1024 * 1024
+)
+
+// Compact rewrites the store into a fresh file, without the free pages
+// left by the deleted values, and renames it over the store file while
+// holding the lock. It returns the size of the file before and after.
+func (s *Store) Compact() (before, after int64, err error) {
+	path := s.db.Path()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	before = fi.Size()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".compact-*")
+	if err != nil {
+		return before, 0, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := os.Chmod(tmp.Name(), fi.Mode().Perm()); err != nil {
+		return before, 0, err
+	}
+
+	dst, err := bolt.Open(tmp.Name(), fi.Mode().Perm(), nil)
+	if err != nil {
+		return before, 0, err
+	}
+	if err := bolt.Compact(dst, s.db, compactTxMaxSize); err != nil {
+		dst.Close()
+		return before, 0, err
+	}
+	if err := dst.Close(); err != nil {
+		return before, 0, err
+	}
+
+	// the compacted file is locked before taking the place of the store,
+	// so that no other process can open it in the meantime; the processes
+	// waiting for the lock of the old file open the store again (see openBolt).
+	opts := *s.boltOptions
+	opts.Timeout = 0
+	opts.OpenFile = func(_ string, flag int, perm os.FileMode) (*os.File, error) {
+		return os.OpenFile(tmp.Name(), flag, perm)
+	}
+	db, err := bolt.Open(path, 0600, &opts)
+	if err != nil {
+		return before, 0, err
+	}
+	if err := db.Sync(); err != nil {
+		db.Close()
+		return before, 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		db.Close()
+		return before, 0, err
+	}
+	syncDir(filepath.Dir(path))
+
+	s.db.Close()
+	s.db = db
+
+	if fi, err = os.Stat(path); err != nil {
+		return before, 0, err
+	}
+	return before, fi.Size(), nil
+}
+
+// syncDir flushes the entries of the directory, such as a rename.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// shouldCompact reports whether the free space in the file exceeds
+// the auto-compaction threshold.
+func (s *Store) shouldCompact() bool {
+	if s.db.IsReadOnly() {
+		return false
+	}
+
+	fi, err := os.Stat(s.db.Path())
+	if err != nil || fi.Size() == 0 {
+		return false
+	}
+
+	st := s.db.Stats()
+	free := int64(st.FreeAlloc)
+	return free >= minCompactFree && float64(free)/float64(fi.Size()) >= s.compactThreshold
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.kvs")
+
+	db, err := New(Options{BucketName: "data", Path: path, CompactThreshold: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if _, err := db.SetRaw(k, bytes.NewReader(make([]byte, 2*ChunkSize)), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Set("small", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a process waiting for the lock must find the compacted store
+	waiting := make(chan *Store)
+	go func() {
+		other, err := New(Options{BucketName: "data", Path: path, Timeout: 5 * time.Second})
+		if err != nil {
+			t.Error(err)
+		}
+		waiting <- other
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	before, after, err := db.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after >= before {
+		t.Fatalf("expected the file to shrink, got %d -> %d", before, after)
+	}
+	if got, err := db.Get("small"); err != nil || string(got) != "value" {
+		t.Fatalf("expected 'value', got '%s', %v", got, err)
+	}
+
+	// the waiting process gets the lock once the store is closed
+	if err := db.db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	other := <-waiting
+	if other == nil {
+		t.FailNow()
+	}
+	if got, err := other.Get("small"); err != nil || string(got) != "value" {
+		t.Fatalf("expected 'value', got '%s', %v", got, err)
+	}
+	if err := other.Set("new", []byte("kept")); err != nil {
+		t.Fatal(err)
+	}
+	other.db.Close()
+
+	if db, err = New(Options{BucketName: "data", Path: path, CompactThreshold: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.Get("new"); err != nil || string(got) != "kept" {
+		t.Fatalf("expected 'kept', got '%s', %v", got, err)
+	}
+
+	// the next chunked value must not reuse the ids of the existing ones
+	if _, err := db.SetRaw("e", bytes.NewReader(make([]byte, 2*ChunkSize)), 0); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := db.Check(); err != nil || len(report.Problems) != 0 {
+		t.Fatalf("expected no problems, got %v, %v", report.Problems, err)
+	}
+
+	for _, k := range []string{"b", "c", "d", "e"} {
+		if err := db.Delete(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fi, _ := os.Stat(path)
+	size := fi.Size()
+
+	// closing triggers the auto-compaction
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ = os.Stat(path); fi.Size() >= size {
+		t.Fatalf("expected the file to be compacted on close, got %d -> %d", size, fi.Size())
+	}
+}
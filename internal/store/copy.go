@@ -0,0 +1,235 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// ErrKeyNotFound is returned when the key supplied does not exists
+	ErrKeyNotFound = errors.New("kvs: key not found")
+	// ErrKeyExists is returned when the destination key already exists
+	ErrKeyExists = errors.New("kvs: key already exists")
+	// ErrBucketExists is returned when the destination bucket already exists
+	ErrBucketExists = errors.New("kvs: bucket already exists")
+)
+
+// Copy copies, byte for byte, the value stored at srcKey in srcBucket
+// to dstKey in dstBucket. The destination bucket is created if needed.
+// An existing destination key is replaced only if overwrite is true.
+func (s *Store) Copy(srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.copyKey(tx, srcBucket, srcKey, dstBucket, dstKey, overwrite)
+	})
+}
+
+// Move moves the value stored at srcKey in srcBucket to dstKey
+// in dstBucket within a single transaction.
+// An existing destination key is replaced only if overwrite is true.
+func (s *Store) Move(srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) error {
+	// the keys may differ only before the normalization
+	srcKey, dstKey = s.keyPolicy.Normalize(srcKey), s.keyPolicy.Normalize(dstKey)
+	if srcBucket == dstBucket && srcKey == dstKey {
+		return nil
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		v, err := s.get(tx, srcBucket, srcKey)
+		if err != nil {
+			return err
+		}
+		// the chunks of a large value are moved along with its manifest
+		val := append([]byte{}, v...)
+
+		dst, err := s.createBucket(tx, dstBucket)
+		if err != nil {
+			return err
+		}
+
+		if !overwrite {
+			if _, err := s.get(tx, dstBucket, dstKey); err == nil {
+				return ErrKeyExists
+			}
+		}
+
+		if err := s.putKey(tx, dst, dstBucket, dstKey, val); err != nil {
+			return err
+		}
+
+		src, err := s.bucket(tx, srcBucket)
+		if err != nil {
+			return err
+		}
+		id, err := s.keyID(srcBucket, srcKey)
+		if err != nil {
+			return err
+		}
+		if err := s.moveExpiryTo(tx, srcBucket, id, dstBucket, dstKey); err != nil {
+			return err
+		}
+		if err := src.Delete(id); err != nil {
+			return err
+		}
+		if err := s.logChange(tx, OpDelete, srcBucket, s.keyPolicy.Normalize(srcKey)); err != nil {
+			return err
+		}
+		return s.unregister(tx, id)
+	})
+}
+
+// RenameBucket renames a bucket, keeping all its keys.
+// Returns an error if the source bucket cannot be found
+// or if the destination bucket already exists.
+func (s *Store) RenameBucket(from, to string) error {
+	if from == to {
+		return nil
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		src, err := s.bucket(tx, from)
+		if err != nil {
+			return err
+		}
+		if src == nil {
+			return ErrBucketNotFound
+		}
+		exists, err := s.bucket(tx, to)
+		if err != nil {
+			return err
+		}
+		if exists != nil {
+			return ErrBucketExists
+		}
+
+		dst, err := s.createBucket(tx, to)
+		if err != nil {
+			return err
+		}
+
+		fromID, err := s.bucketID(from)
+		if err != nil {
+			return err
+		}
+		toID, err := s.bucketID(to)
+		if err != nil {
+			return err
+		}
+		// before the names of the keys are unregistered
+		err = remapExpiries(tx, func(bid, kid []byte) ([]byte, []byte, error) {
+			if !bytes.Equal(bid, fromID) {
+				return bid, kid, nil
+			}
+			if !s.hidden {
+				return toID, kid, nil
+			}
+			name, err := s.realName(tx, kid)
+			if err != nil {
+				return nil, nil, err
+			}
+			nkid, err := s.keyID(to, name)
+			return toID, nkid, err
+		})
+		if err != nil {
+			return err
+		}
+
+		err = src.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			name, err := s.realName(tx, k)
+			if err != nil {
+				return err
+			}
+			if err := s.logChange(tx, OpDelete, from, name); err != nil {
+				return err
+			}
+			if !s.hidden {
+				return s.logChange(tx, OpSet, to, name)
+			}
+
+			// the key identifiers depend on the bucket name
+			if err := s.unregister(tx, k); err != nil {
+				return err
+			}
+			return s.putKey(tx, dst, to, name, v)
+		})
+		if err == nil && !s.hidden {
+			err = copyBucket(src, dst)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.DeleteBucket(fromID); err != nil {
+			return err
+		}
+		return s.unregister(tx, fromID)
+	})
+}
+
+func (s *Store) copyKey(tx *bolt.Tx, srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) error {
+	v, err := s.get(tx, srcBucket, srcKey)
+	if err != nil {
+		return err
+	}
+
+	dst, err := s.createBucket(tx, dstBucket)
+	if err != nil {
+		return err
+	}
+
+	if !overwrite {
+		if _, err := s.get(tx, dstBucket, dstKey); err == nil {
+			return ErrKeyExists
+		}
+	}
+
+	// v is only valid during the transaction and it is about to be
+	// written in the same one; large values get their own chunks.
+	val, err := cloneChunks(tx, v)
+	if err != nil {
+		return err
+	}
+
+	return s.putKey(tx, dst, dstBucket, dstKey, val)
+}
+
+// moveExpiryTo moves the expiration of the key srcID of srcBucket to dstKey in dstBucket.
+func (s *Store) moveExpiryTo(tx *bolt.Tx, srcBucket string, srcID []byte, dstBucket, dstKey string) error {
+	srcBid, err := s.bucketID(srcBucket)
+	if err != nil {
+		return err
+	}
+	dstBid, err := s.bucketID(dstBucket)
+	if err != nil {
+		return err
+	}
+	dstID, err := s.keyID(dstBucket, dstKey)
+	if err != nil {
+		return err
+	}
+	return moveExpiry(tx, srcBid, srcID, dstBid, dstID)
+}
+
+// copyBucket recursively copies all the keys and nested buckets
+// of src to dst, along with their sequences.
+func copyBucket(src, dst *bolt.Bucket) error {
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+
+		nested, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(src.Bucket(k), nested)
+	})
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCopyAndMove(t *testing.T) {
+	db := newTestStore(t, "google")
+
+	if err := db.Set("user", []byte("john.doe@gmail.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Copy("google", "user", "work", "email", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Copy("google", "user", "work", "email", false); err != ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+	if err := db.Copy("google", "missing", "work", "email", true); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	if err := db.Move("google", "user", "google", "login", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := db.Get("user"); v != nil {
+		t.Fatalf("expected 'user' to be moved, got %q", v)
+	}
+	if v, _ := db.Get("login"); string(v) != "john.doe@gmail.com" {
+		t.Fatalf("unexpected value for 'login': %q", v)
+	}
+
+	work := &Store{db: db.db, bucketName: "work"}
+	if v, _ := work.Get("email"); string(v) != "john.doe@gmail.com" {
+		t.Fatalf("unexpected value for 'email': %q", v)
+	}
+}
+
+func TestMoveNormalized(t *testing.T) {
+	for _, p := range []KeyPolicy{SlugKeys, LowercaseKeys} {
+		db := newTestStore(t, "notes")
+		if _, err := db.MigrateKeys(p, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Set("My Key", []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+
+		// the same key once normalized: the value must survive
+		dst := p.Normalize("My Key")
+		for _, overwrite := range []bool{false, true} {
+			if err := db.Move("notes", "My Key", "notes", dst, overwrite); err != nil {
+				t.Fatalf("%s: %v", p, err)
+			}
+			if v, _ := db.Get(dst); string(v) != "value" {
+				t.Fatalf("%s: expected the value to be kept, got %q", p, v)
+			}
+		}
+	}
+}
+
+func TestRenameBucket(t *testing.T) {
+	db := newTestStore(t, "google")
+
+	db.Set("user", []byte("john"))
+	db.Set("pass", []byte("secret"))
+
+	if err := db.RenameBucket("missing", "other"); err != ErrBucketNotFound {
+		t.Fatalf("expected ErrBucketNotFound, got %v", err)
+	}
+
+	if err := db.RenameBucket("google", "gmail"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := db.Buckets(), []string{"gmail"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want: %v, got: %v", want, got)
+	}
+
+	gmail := &Store{db: db.db, bucketName: "gmail"}
+	if got, want := gmail.Keys(), []string{"pass", "user"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want: %v, got: %v", want, got)
+	}
+}
@@ -0,0 +1,309 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// expiresBucket holds the expiration time of the keys, in unix
+// milliseconds, by the bucket and key ids joined by joinPair.
+const expiresBucket = reservedPrefix + "expires__"
+
+// now is replaced by the tests.
+var now = time.Now
+
+// Expire sets when the key expires: expired keys are not found and
+// are deleted by PurgeExpired. A zero time removes the expiration,
+// a time in the past deletes the key.
+func (s *Store) Expire(k string, at time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := s.bucket(tx, s.bucketName)
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			return ErrBucketNotFound
+		}
+
+		if _, err := s.get(tx, s.bucketName, k); err != nil {
+			return err
+		}
+
+		if !at.IsZero() && !at.After(now()) {
+			return s.deleteKey(tx, b, s.bucketName, k)
+		}
+
+		id, err := s.expiryID(s.bucketName, k)
+		if err != nil {
+			return err
+		}
+		return setExpiry(tx, id, at)
+	})
+}
+
+// ExpiresAt returns when the key expires, the zero time if it does not.
+func (s *Store) ExpiresAt(k string) (time.Time, error) {
+	var res time.Time
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if _, err := s.get(tx, s.bucketName, k); err != nil {
+			return err
+		}
+
+		id, err := s.expiryID(s.bucketName, k)
+		if err != nil {
+			return err
+		}
+		res = expiryOf(tx, id)
+		return nil
+	})
+
+	return res, err
+}
+
+// PurgeExpired deletes the expired keys of all the buckets
+// and returns how many have been deleted.
+func (s *Store) PurgeExpired() (int, error) {
+	var count int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		count, err = s.purgeExpiredTx(tx)
+		return err
+	})
+
+	return count, err
+}
+
+func (s *Store) purgeExpiredTx(tx *bolt.Tx) (int, error) {
+	exp := tx.Bucket([]byte(expiresBucket))
+	if exp == nil {
+		return 0, nil
+	}
+
+	var ids [][]byte
+	t := now()
+	exp.ForEach(func(id, v []byte) error {
+		if !decodeTime(v).After(t) {
+			ids = append(ids, append([]byte{}, id...))
+		}
+		return nil
+	})
+
+	var count int
+	for _, id := range ids {
+		if err := exp.Delete(id); err != nil {
+			return 0, err
+		}
+
+		bid, kid := splitPair(id)
+		if bid == nil {
+			continue
+		}
+		b := tx.Bucket(bid)
+		if b == nil || b.Get(kid) == nil {
+			continue
+		}
+		if err := s.logPurge(tx, bid, kid); err != nil {
+			return 0, err
+		}
+		if err := deleteValue(tx, b, kid); err != nil {
+			return 0, err
+		}
+		if err := s.unregister(tx, kid); err != nil {
+			return 0, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// logPurge logs the deletion of the expired key, both as ids.
+func (s *Store) logPurge(tx *bolt.Tx, bid, kid []byte) error {
+	bucket, err := s.realName(tx, bid)
+	if err != nil {
+		return err
+	}
+	key, err := s.realName(tx, kid)
+	if err != nil {
+		return err
+	}
+	return s.logChange(tx, OpDelete, bucket, key)
+}
+
+// expired reports whether the key of the bucket, both as ids, has expired.
+func expired(tx *bolt.Tx, bid, kid []byte) bool {
+	at := expiryOf(tx, joinPair(bid, kid))
+	return !at.IsZero() && !at.After(now())
+}
+
+func expiryOf(tx *bolt.Tx, id []byte) time.Time {
+	exp := tx.Bucket([]byte(expiresBucket))
+	if exp == nil {
+		return time.Time{}
+	}
+	v := exp.Get(id)
+	if v == nil {
+		return time.Time{}
+	}
+	return decodeTime(v)
+}
+
+// setExpiry sets the expiration of the key with the given id,
+// removing it if at is the zero time.
+func setExpiry(tx *bolt.Tx, id []byte, at time.Time) error {
+	if at.IsZero() {
+		if exp := tx.Bucket([]byte(expiresBucket)); exp != nil {
+			return exp.Delete(id)
+		}
+		return nil
+	}
+
+	exp, err := tx.CreateBucketIfNotExists([]byte(expiresBucket))
+	if err != nil {
+		return err
+	}
+	return exp.Put(id, encodeTime(at))
+}
+
+// deleteExpiry removes the expiration of the key, if any.
+func deleteExpiry(tx *bolt.Tx, bid, kid []byte) error {
+	exp := tx.Bucket([]byte(expiresBucket))
+	if exp == nil {
+		return nil
+	}
+	return exp.Delete(joinPair(bid, kid))
+}
+
+// deleteBucketExpiries removes the expirations of the keys of the bucket.
+func deleteBucketExpiries(tx *bolt.Tx, bid []byte) error {
+	exp := tx.Bucket([]byte(expiresBucket))
+	if exp == nil {
+		return nil
+	}
+
+	prefix := joinPair(bid, nil)
+	c := exp.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveExpiry moves the expiration of a key, both as ids, to another
+// key, replacing the expiration of the latter.
+func moveExpiry(tx *bolt.Tx, fromBid, fromKid, toBid, toKid []byte) error {
+	exp := tx.Bucket([]byte(expiresBucket))
+	if exp == nil {
+		return nil
+	}
+
+	from, to := joinPair(fromBid, fromKid), joinPair(toBid, toKid)
+	if bytes.Equal(from, to) {
+		return nil
+	}
+
+	v := exp.Get(from)
+	if v == nil {
+		return exp.Delete(to)
+	}
+	v = append([]byte{}, v...)
+	if err := exp.Delete(from); err != nil {
+		return err
+	}
+	return exp.Put(to, v)
+}
+
+// remapExpiries changes the ids of the expirations with fn, used when
+// the ids of the keys change; those mapped to a nil bucket id are dropped.
+func remapExpiries(tx *bolt.Tx, fn func(bid, kid []byte) ([]byte, []byte, error)) error {
+	exp := tx.Bucket([]byte(expiresBucket))
+	if exp == nil {
+		return nil
+	}
+
+	type row struct{ id, nid, v []byte }
+	var moved []row
+	err := exp.ForEach(func(id, v []byte) error {
+		bid, kid := splitPair(id)
+		nbid, nkid, err := fn(bid, kid)
+		if err != nil {
+			return err
+		}
+
+		var nid []byte
+		if nbid != nil {
+			nid = joinPair(nbid, nkid)
+		}
+		if !bytes.Equal(nid, id) {
+			moved = append(moved, row{
+				append([]byte{}, id...), nid, append([]byte{}, v...),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// all the old rows go first: a new id may be an old one
+	for _, r := range moved {
+		if err := exp.Delete(r.id); err != nil {
+			return err
+		}
+	}
+	for _, r := range moved {
+		if r.nid == nil {
+			continue
+		}
+		if err := exp.Put(r.nid, r.v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) expiryID(bucket, k string) ([]byte, error) {
+	bid, err := s.bucketID(bucket)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := s.keyID(bucket, k)
+	if err != nil {
+		return nil, err
+	}
+	return joinPair(bid, kid), nil
+}
+
+// joinPair joins a and b, prefixing them with the length of a.
+func joinPair(a, b []byte) []byte {
+	res := binary.AppendUvarint(nil, uint64(len(a)))
+	res = append(res, a...)
+	return append(res, b...)
+}
+
+// splitPair splits what has been joined by joinPair,
+// returning nils if v is malformed.
+func splitPair(v []byte) (a, b []byte) {
+	n, size := binary.Uvarint(v)
+	if size <= 0 || uint64(len(v)-size) < n {
+		return nil, nil
+	}
+	v = v[size:]
+	return v[:n], v[n:]
+}
+
+func encodeTime(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t.UnixMilli()))
+}
+
+func decodeTime(v []byte) time.Time {
+	if len(v) != 8 {
+		return time.Time{}
+	}
+	return time.UnixMilli(int64(binary.BigEndian.Uint64(v)))
+}
@@ -0,0 +1,228 @@
+package store
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestExpire(t *testing.T) {
+	db := newTestStore(t, "cache")
+
+	start := time.Now()
+	now = func() time.Time { return start }
+	defer func() { now = time.Now }()
+
+	for _, k := range []string{"a", "b", "c"} {
+		if err := db.Set(k, []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Expire("a", start.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Expire("b", start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Expire("missing", start.Add(time.Hour)); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	at, err := db.ExpiresAt("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if at.UnixMilli() != start.Add(time.Hour).UnixMilli() {
+		t.Fatalf("unexpected expiration: %v", at)
+	}
+	if at, _ := db.ExpiresAt("c"); !at.IsZero() {
+		t.Fatalf("expected no expiration, got %v", at)
+	}
+
+	now = func() time.Time { return start.Add(2 * time.Second) }
+
+	if v, err := db.Get("a"); err != nil || v != nil {
+		t.Fatalf("expected 'a' to be expired, got %q, %v", v, err)
+	}
+	if _, err := db.Stat("a"); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if got := db.Keys(); !reflect.DeepEqual(got, []string{"b", "c"}) {
+		t.Fatalf("unexpected keys: %v", got)
+	}
+
+	n, err := db.PurgeExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 purged key, got %d", n)
+	}
+
+	// written again, an expired key does not expire anymore
+	if err := db.Expire("b", start); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("b", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if at, err := db.ExpiresAt("b"); err != nil || !at.IsZero() {
+		t.Fatalf("expected no expiration, got %v, %v", at, err)
+	}
+
+	if err := db.Expire("c", start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Expire("c", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if at, _ := db.ExpiresAt("c"); !at.IsZero() {
+		t.Fatalf("expected no expiration, got %v", at)
+	}
+
+	if err := db.Expire("c", start.Add(3*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteBucket("cache"); err != nil {
+		t.Fatal(err)
+	}
+	db.db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte(expiresBucket)).Stats().KeyN; n != 0 {
+			t.Fatalf("expected no expirations left, got %d", n)
+		}
+		return nil
+	})
+}
+
+func TestExpireRenamed(t *testing.T) {
+	db, err := New(Options{
+		BucketName: "a",
+		Path:       filepath.Join(t.TempDir(), "test.kvs"),
+		Key:        bytes.Repeat([]byte{0x42}, 32),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	at := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+
+	check := func(step, bucket, k string, want time.Time) {
+		t.Helper()
+
+		got, err := db.InBucket(bucket).ExpiresAt(k)
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		if !got.Equal(want) {
+			t.Fatalf("%s: expected %s/%s to expire at %v, got %v", step, bucket, k, want, got)
+		}
+		db.db.View(func(tx *bolt.Tx) error {
+			if n := tx.Bucket([]byte(expiresBucket)).Stats().KeyN; n != 1 {
+				t.Fatalf("%s: expected 1 expiration, got %d", step, n)
+			}
+			return nil
+		})
+	}
+
+	if err := db.Set("k1", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Expire("k1", at); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Move("a", "k1", "b", "k2", false); err != nil {
+		t.Fatal(err)
+	}
+	check("move", "b", "k2", at)
+	if err := db.Set("k1", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	check("move", "a", "k1", time.Time{})
+
+	if err := db.RenameBucket("b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	check("rename", "c", "k2", at)
+
+	if err := db.HideNames(); err != nil {
+		t.Fatal(err)
+	}
+	check("hide", "c", "k2", at)
+
+	if err := db.RenameBucket("c", "d"); err != nil {
+		t.Fatal(err)
+	}
+	check("hidden rename", "d", "k2", at)
+
+	if err := db.Move("d", "k2", "d", "K3", false); err != nil {
+		t.Fatal(err)
+	}
+	check("hidden move", "d", "K3", at)
+
+	if _, err := db.Rekey(bytes.Repeat([]byte{0x24}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	check("rekey", "d", "K3", at)
+
+	if _, err := db.MigrateKeys(LowercaseKeys, false); err != nil {
+		t.Fatal(err)
+	}
+	check("migrate", "d", "k3", at)
+}
+
+func TestExpireWalk(t *testing.T) {
+	db := newTestStore(t, "cache")
+
+	start := time.Now()
+	now = func() time.Time { return start }
+	defer func() { now = time.Now }()
+
+	for _, k := range []string{"a", "b", "c"} {
+		if err := db.Set(k, []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Expire("a", start.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Expire("b", start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	now = func() time.Time { return start.Add(2 * time.Second) }
+
+	var keys []string
+	err := db.Walk("cache", func(_, key string, _ []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{"b", "c"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	n, err := db.Rewrite("cache", func(_, _ string, v []byte) ([]byte, bool, error) {
+		return bytes.ToUpper(v), true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 rewritten values, got %d", n)
+	}
+	if v, err := db.Get("a"); err != nil || v != nil {
+		t.Fatalf("expected 'a' to stay expired, got %q, %v", v, err)
+	}
+	if at, err := db.ExpiresAt("b"); err != nil || !at.Equal(start.Add(time.Hour).Truncate(time.Millisecond)) {
+		t.Fatalf("expected 'b' to keep its expiration, got %v, %v", at, err)
+	}
+}
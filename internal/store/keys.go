@@ -0,0 +1,133 @@
+package store
+
+import (
+	"errors"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrKeyCollisions is returned when different keys
+// of a bucket would be normalized to the same key.
+var ErrKeyCollisions = errors.New("kvs: keys would collide once normalized")
+
+// Collision lists the keys of a bucket normalized to the same Key.
+type Collision struct {
+	Bucket string
+	Key    string
+	Keys   []string
+}
+
+// KeyMigration is the result of MigrateKeys.
+type KeyMigration struct {
+	// Renamed is the number of keys renamed, or to rename.
+	Renamed int
+	// Collisions prevent the migration, if any.
+	Collisions []Collision
+}
+
+// MigrateKeys renames the existing keys according to the policy,
+// in a single transaction, and then records the policy in the store
+// metadata, so that every key is normalized from then on.
+// Nothing is changed if dryRun is true or if some keys would collide,
+// in which case the error is ErrKeyCollisions.
+func (s *Store) MigrateKeys(p KeyPolicy, dryRun bool) (KeyMigration, error) {
+	var res KeyMigration
+
+	prev := s.keyPolicy
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		type rename struct {
+			bucket string
+			id     []byte
+			from   string
+			to     string
+		}
+		var todo []rename
+
+		err := tx.ForEach(func(bid []byte, b *bolt.Bucket) error {
+			if isReserved(bid) {
+				return nil
+			}
+			bucket, err := s.realName(tx, bid)
+			if err != nil {
+				return err
+			}
+
+			groups := map[string][]string{}
+			err = b.ForEach(func(id, v []byte) error {
+				if v == nil {
+					return nil
+				}
+				name, err := s.realName(tx, id)
+				if err != nil {
+					return err
+				}
+
+				to := p.Normalize(name)
+				groups[to] = append(groups[to], name)
+				if to != name {
+					todo = append(todo, rename{bucket, append([]byte{}, id...), name, to})
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for to, names := range groups {
+				if len(names) > 1 {
+					sort.Strings(names)
+					res.Collisions = append(res.Collisions, Collision{bucket, to, names})
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		res.Renamed = len(todo)
+		if len(res.Collisions) > 0 {
+			sort.Slice(res.Collisions, func(i, j int) bool {
+				a, b := res.Collisions[i], res.Collisions[j]
+				return a.Bucket < b.Bucket || (a.Bucket == b.Bucket && a.Key < b.Key)
+			})
+			return ErrKeyCollisions
+		}
+		if dryRun {
+			return nil
+		}
+
+		s.keyPolicy = p
+		for _, el := range todo {
+			b, err := s.bucket(tx, el.bucket)
+			if err != nil {
+				return err
+			}
+			// the chunks of a large value are moved along with its manifest
+			val := append([]byte{}, b.Get(el.id)...)
+			if err := b.Delete(el.id); err != nil {
+				return err
+			}
+			if err := s.unregister(tx, el.id); err != nil {
+				return err
+			}
+			if err := s.logChange(tx, OpDelete, el.bucket, el.from); err != nil {
+				return err
+			}
+			if err := s.putKey(tx, b, el.bucket, el.to, val); err != nil {
+				return err
+			}
+			if err := s.moveExpiryTo(tx, el.bucket, el.id, el.bucket, el.to); err != nil {
+				return err
+			}
+		}
+
+		return putMeta(tx, keyPolicyKey, p.String())
+	})
+	if err != nil || dryRun {
+		s.keyPolicy = prev
+	}
+
+	return res, err
+}
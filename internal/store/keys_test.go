@@ -0,0 +1,106 @@
+package store
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMigrateKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.kvs")
+
+	db, err := New(Options{BucketName: "notes", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"Todo", "Done", "done"} {
+		if err := db.Set(k, []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.SetRaw("Large", bytes.NewReader(make([]byte, ChunkSize+1)), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := db.MigrateKeys(LowercaseKeys, false)
+	if err != ErrKeyCollisions {
+		t.Fatalf("expected ErrKeyCollisions, got %v", err)
+	}
+	want := []Collision{{Bucket: "notes", Key: "done", Keys: []string{"Done", "done"}}}
+	if !reflect.DeepEqual(res.Collisions, want) {
+		t.Fatalf("expected %v, got %v", want, res.Collisions)
+	}
+	if db.KeyPolicy() != KeysAsIs {
+		t.Fatalf("expected the policy unchanged, got %s", db.KeyPolicy())
+	}
+
+	if err := db.Delete("Done"); err != nil {
+		t.Fatal(err)
+	}
+	res, err = db.MigrateKeys(LowercaseKeys, true)
+	if err != nil || res.Renamed != 2 {
+		t.Fatalf("expected 2 keys to rename, got %d, %v", res.Renamed, err)
+	}
+	if got, _ := db.Get("todo"); got != nil {
+		t.Fatal("expected no change with dry run")
+	}
+
+	if _, err := db.MigrateKeys(LowercaseKeys, false); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// the policy is kept in the store metadata
+	db, err = New(Options{BucketName: "notes", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if db.KeyPolicy() != LowercaseKeys {
+		t.Fatalf("expected lowercase policy, got %s", db.KeyPolicy())
+	}
+	keys := db.Keys()
+	sort.Strings(keys)
+	if want := []string{"done", "large", "todo"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("expected %v, got %v", want, keys)
+	}
+	if got, err := db.Get("TODO"); err != nil || string(got) != "Todo" {
+		t.Fatalf("expected 'Todo', got '%s', %v", got, err)
+	}
+
+	if err := db.Set("New Key", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.Get("new key"); string(got) != "v" {
+		t.Fatalf("expected 'v', got '%s'", got)
+	}
+
+	var buf bytes.Buffer
+	if _, err := db.GetRaw("LARGE", &buf); err != nil || buf.Len() != ChunkSize+1 {
+		t.Fatalf("expected the chunked value, got %d bytes, %v", buf.Len(), err)
+	}
+	if report, err := db.Check(); err != nil || len(report.Problems) != 0 {
+		t.Fatalf("expected no problems, got %v, %v", report.Problems, err)
+	}
+}
+
+func TestKeyPolicyNormalize(t *testing.T) {
+	tests := []struct {
+		policy KeyPolicy
+		input  string
+		want   string
+	}{
+		{KeysAsIs, "My Key", "My Key"},
+		{LowercaseKeys, "My Key", "my key"},
+		{SlugKeys, "Hello Wonderful World!", "hello-wonderful-world"},
+	}
+
+	for _, tc := range tests {
+		if got := tc.policy.Normalize(tc.input); got != tc.want {
+			t.Fatalf("%s: expected '%s', got '%s'", tc.policy, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+
+	"github.com/lucasepe/kvs/internal/aes"
+)
+
+// minCipherTextSize is the size of the ciphertext of an empty
+// plaintext (12 bytes of header or nonce + 16 bytes tag).
+const minCipherTextSize = 28
+
+// DecryptLegacy decrypts a base64 encoded value written
+// by the older versions (see LooksLegacyEncrypted).
+func DecryptLegacy(dat, key []byte) ([]byte, error) {
+	buf, err := base64.RawStdEncoding.DecodeString(string(dat))
+	if err != nil {
+		return nil, err
+	}
+
+	if aes.IsStream(buf) {
+		r, err := aes.NewReader(bytes.NewReader(buf), key)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	return aes.GcmDecrypt(buf, key)
+}
+
+// LooksLegacyEncrypted reports whether the value could have been
+// encrypted by the older versions, which stored the ciphertext
+// as base64 without any marker: this is only a guess based on
+// the encoding and the length.
+func LooksLegacyEncrypted(dat []byte) bool {
+	enc := base64.RawStdEncoding
+	if enc.DecodedLen(len(dat)) < minCipherTextSize {
+		return false
+	}
+
+	for _, c := range dat {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '+', c == '/':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// legacyValue returns the plaintext of the raw value v if it is a blob
+// encrypted with key by the older versions. The ciphertext is always
+// authenticated, so plain values that only look like base64 are refused.
+func legacyValue(v, key []byte) ([]byte, bool) {
+	if len(v) == 0 || v[0] != 0 || !LooksLegacyEncrypted(v[1:]) {
+		return nil, false
+	}
+
+	res, err := DecryptLegacy(v[1:], key)
+	return res, err == nil
+}
@@ -0,0 +1,265 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/lucasepe/kvs/internal/aes"
+	"github.com/lucasepe/toolbox/slug"
+	bolt "go.etcd.io/bbolt"
+)
+
+// metaBucket holds the settings of the store.
+const metaBucket = reservedPrefix + "meta__"
+
+var (
+	versionKey   = []byte("version")
+	keyPolicyKey = []byte("key-policy")
+)
+
+// SchemaVersion is the version of the store format written by this
+// package; stores without a version predate the metadata and are
+// version 0.
+const SchemaVersion = 2
+
+var (
+	// ErrOutdated is returned opening a store written by an older
+	// version of kvs, which must be migrated first (see Migrate).
+	ErrOutdated = errors.New("kvs: the store has been written by an older version and must be migrated")
+	// ErrTooNew is returned opening a store written by a newer version of kvs.
+	ErrTooNew = errors.New("kvs: the store has been written by a newer version")
+)
+
+// migrations upgrade the store format: migrations[i]
+// turns version i into version i+1.
+var migrations = []func(tx *bolt.Tx) error{
+	// 0 -> 1: the metadata bucket holds the version
+	func(tx *bolt.Tx) error { return nil },
+	// 1 -> 2: every value starts with its flags
+	markValues,
+}
+
+// legacyManifest is how the manifests of the chunked values
+// started before the values recorded their flags.
+var legacyManifest = []byte{0x00, 'K', 'V', 'c'}
+
+// markValues prefixes the values of all the buckets with their flags,
+// found looking at the headers of their data: the encrypted values are
+// marked with flagLegacy, since their data cannot be read.
+func markValues(tx *bolt.Tx) error {
+	chunks := tx.Bucket([]byte(chunksBucket))
+
+	return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		if isReserved(name) {
+			return nil
+		}
+
+		// the cursor is moved back to the key after each Put
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if v == nil {
+				continue
+			}
+
+			var val []byte
+			if bytes.HasPrefix(v, legacyManifest) {
+				m, err := decodeManifest(append([]byte{flagChunked}, v[len(legacyManifest):]...))
+				if err != nil {
+					return err
+				}
+				var head []byte
+				if chunks != nil {
+					head = chunks.Get(chunkKey(m.id, 0))
+				}
+				m.flags = legacyFlags(head)
+				m.size++
+				val = m.encode()
+			} else {
+				val = append([]byte{legacyFlags(v)}, v...)
+			}
+			key := append([]byte{}, k...)
+			if err := b.Put(key, val); err != nil {
+				return err
+			}
+			c.Seek(key)
+		}
+		return nil
+	})
+}
+
+// legacyFlags returns the flags of the data of a value written
+// before the flags were recorded, looking at its headers.
+func legacyFlags(dat []byte) byte {
+	if aes.IsStream(dat) {
+		return flagEncrypted | flagLegacy
+	}
+
+	var flags byte
+	head := dat
+	if bytes.HasPrefix(dat, compressHeader) && len(dat) > len(compressHeader) {
+		flags |= flagCompressed
+
+		head = make([]byte, len(listHeader))
+		r, err := NewDecoder(io.MultiReader(bytes.NewReader([]byte{flags}), bytes.NewReader(dat)), nil)
+		if err == nil {
+			n, _ := io.ReadFull(r, head)
+			head = head[:n]
+		}
+	}
+
+	return flags | kindFlags(collectionKind(head))
+}
+
+// KeyPolicy is how the store normalizes the keys,
+// before reading or writing them.
+type KeyPolicy byte
+
+const (
+	// KeysAsIs keeps the keys as they are.
+	KeysAsIs KeyPolicy = iota
+	// SlugKeys transforms the keys into slugs
+	// (e.g. 'My Key!' becomes 'my-key').
+	SlugKeys
+	// LowercaseKeys transforms the keys to lower case.
+	LowercaseKeys
+)
+
+func (p KeyPolicy) String() string {
+	switch p {
+	case SlugKeys:
+		return "slug"
+	case LowercaseKeys:
+		return "lowercase"
+	default:
+		return "none"
+	}
+}
+
+// ParseKeyPolicy returns the key policy with the specified name.
+func ParseKeyPolicy(name string) (KeyPolicy, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return KeysAsIs, nil
+	case "slug":
+		return SlugKeys, nil
+	case "lowercase":
+		return LowercaseKeys, nil
+	default:
+		return KeysAsIs, fmt.Errorf("kvs: unsupported key policy: %s", name)
+	}
+}
+
+// Normalize returns the key according to the policy.
+func (p KeyPolicy) Normalize(k string) string {
+	switch p {
+	case SlugKeys:
+		return slug.Slugify(k)
+	case LowercaseKeys:
+		return strings.ToLower(k)
+	default:
+		return k
+	}
+}
+
+// KeyPolicy returns how the store normalizes the keys.
+func (s *Store) KeyPolicy() KeyPolicy {
+	return s.keyPolicy
+}
+
+// Version returns the format version of the store.
+func (s *Store) Version() int {
+	return s.version
+}
+
+// Migrate upgrades the store to SchemaVersion, one version at a time,
+// each in its own transaction. It returns the versions before and after.
+func (s *Store) Migrate() (from, to int, err error) {
+	from = s.version
+	for s.version < SchemaVersion {
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			if err := migrations[s.version](tx); err != nil {
+				return err
+			}
+			return putMeta(tx, versionKey, strconv.Itoa(s.version+1))
+		})
+		if err != nil {
+			return from, s.version, fmt.Errorf("kvs: migration to version %d failed: %w", s.version+1, err)
+		}
+		s.version++
+	}
+
+	return from, s.version, nil
+}
+
+// initMeta records the format version of a new, empty, store;
+// a read-only one is just considered up to date.
+func (s *Store) initMeta() error {
+	var empty bool
+	s.db.View(func(tx *bolt.Tx) error {
+		k, _ := tx.Cursor().First()
+		empty = k == nil
+		return nil
+	})
+	if !empty {
+		return nil
+	}
+
+	s.version = SchemaVersion
+	if s.db.IsReadOnly() {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putMeta(tx, versionKey, strconv.Itoa(SchemaVersion))
+	})
+}
+
+// checkVersion refuses the stores whose version differs
+// from SchemaVersion, unless outdated ones are allowed.
+func (s *Store) checkVersion(outdated bool) error {
+	switch {
+	case s.version > SchemaVersion:
+		return fmt.Errorf("%w (format version %d, supported %d)", ErrTooNew, s.version, SchemaVersion)
+	case s.version < SchemaVersion && !outdated:
+		return fmt.Errorf("%w (format version %d, current %d)", ErrOutdated, s.version, SchemaVersion)
+	}
+	return nil
+}
+
+// loadMeta reads the settings of the store.
+func (s *Store) loadMeta(tx *bolt.Tx) error {
+	meta := tx.Bucket([]byte(metaBucket))
+	if meta == nil {
+		return nil
+	}
+
+	if v := meta.Get(versionKey); v != nil {
+		n, err := strconv.Atoi(string(v))
+		if err != nil {
+			return fmt.Errorf("kvs: invalid store version: %q", v)
+		}
+		s.version = n
+	}
+
+	if v := meta.Get(keyPolicyKey); v != nil {
+		p, err := ParseKeyPolicy(string(v))
+		if err != nil {
+			return err
+		}
+		s.keyPolicy = p
+	}
+
+	return nil
+}
+
+// putMeta stores a setting of the store.
+func putMeta(tx *bolt.Tx, k []byte, v string) error {
+	meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return err
+	}
+	return meta.Put(k, []byte(v))
+}
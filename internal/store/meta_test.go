@@ -0,0 +1,172 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.kvs")
+
+	// a store written before the metadata
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("notes"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("todo"), []byte("v1")); err != nil {
+			return err
+		}
+
+		// a chunked value, with the manifest of the older versions
+		chunks, err := tx.CreateBucket([]byte(chunksBucket))
+		if err != nil {
+			return err
+		}
+		if err := chunks.Put(chunkKey(1, 0), []byte("large")); err != nil {
+			return err
+		}
+		return b.Put([]byte("big"), append(append([]byte{}, legacyManifest...), 0x05, 0x01, 0x01))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(Options{BucketName: "notes", Path: path}); !errors.Is(err, ErrOutdated) {
+		t.Fatalf("expected ErrOutdated, got %v", err)
+	}
+
+	s, err := New(Options{BucketName: "notes", Path: path, Outdated: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Version() != 0 {
+		t.Fatalf("expected version 0, got %d", s.Version())
+	}
+	from, to, err := s.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != 0 || to != SchemaVersion {
+		t.Fatalf("expected migration from 0 to %d, got %d to %d", SchemaVersion, from, to)
+	}
+	s.Close()
+
+	s, err = New(Options{BucketName: "notes", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Get("todo"); err != nil || string(got) != "v1" {
+		t.Fatalf("expected 'v1', got '%s', %v", got, err)
+	}
+	if got, err := s.Get("big"); err != nil || string(got) != "large" {
+		t.Fatalf("expected 'large', got '%s', %v", got, err)
+	}
+
+	// a store written by a newer version
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return putMeta(tx, versionKey, "99")
+	})
+	s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(Options{Path: path, Outdated: true}); !errors.Is(err, ErrTooNew) {
+		t.Fatalf("expected ErrTooNew, got %v", err)
+	}
+}
+
+func TestMarkValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.kvs")
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	// the data of the values written before the flags
+	enc := &Store{key: key}
+	legacy := func(v []byte, e Encoding, kind Kind) []byte {
+		raw, err := enc.encodeAs(v, e, kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw[1:]
+	}
+	items := [][]byte{[]byte("a"), []byte("b")}
+	values := map[string][]byte{
+		"list":   legacy(encodeCollection(KindList, items), Encoding{Compression: Gzip}, KindList),
+		"secret": legacy(encodeCollection(KindSet, items), Encoding{Encrypted: true}, KindSet),
+	}
+
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if err := putMeta(tx, versionKey, "1"); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket([]byte("data"))
+		if err != nil {
+			return err
+		}
+		for k, v := range values {
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(Options{BucketName: "data", Path: path, Key: key, Outdated: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, _, err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := s.LRange("list", 0, -1); err != nil || len(got) != 2 {
+		t.Fatalf("expected the list, got %v, %v", got, err)
+	}
+	if got, err := s.SMembers("secret"); err != nil || len(got) != 2 {
+		t.Fatalf("expected the set, got %v, %v", got, err)
+	}
+	if info, err := s.Stat("secret"); err != nil || info.Kind != KindSet {
+		t.Fatalf("expected a set, got %+v, %v", info, err)
+	}
+
+	// rekeying records the flags of the encrypted values
+	if _, err := s.Rekey(bytes.Repeat([]byte{0x43}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := s.GetRaw("secret", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if raw := buf.Bytes(); raw[0] != flagEncrypted|flagSet {
+		t.Fatalf("unexpected flags: %#x", raw[0])
+	}
+}
+
+func TestVersionNewStore(t *testing.T) {
+	db := newTestStore(t, "notes")
+
+	if db.Version() != SchemaVersion {
+		t.Fatalf("expected version %d, got %d", SchemaVersion, db.Version())
+	}
+	if got := db.Buckets(); len(got) != 0 {
+		t.Fatalf("expected no buckets, got %v", got)
+	}
+}
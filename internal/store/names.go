@@ -0,0 +1,432 @@
+package store
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lucasepe/kvs/internal/aes"
+	bolt "go.etcd.io/bbolt"
+)
+
+// A store with hidden names keeps its buckets and keys under keyed
+// identifiers, so that the file does not reveal what it holds:
+//
+//	bucket: hex(HMAC-SHA256(nameKey, 'b' | bucket))
+//	key:    hex(HMAC-SHA256(nameKey, 'k' | bucket | 0x00 | key))
+//
+// The name key is derived from the store key. The real names are
+// encrypted with the store key and kept in the reserved names bucket,
+// indexed by identifier, along with a verifier of the key.
+// Once the store is unlocked, all the methods accept and return
+// the real names.
+
+const namesBucket = reservedPrefix + "names__"
+
+var verifierKey = []byte{0x00}
+
+var (
+	// ErrWrongKey is returned when unlocking a store
+	// with hidden names using the wrong key
+	ErrWrongKey = errors.New("kvs: wrong key")
+	// ErrNothingToVerify is returned checking the key of a store
+	// that neither hides the names nor holds encrypted values
+	ErrNothingToVerify = errors.New("kvs: the store holds nothing encrypted to verify the key against")
+)
+
+// HidesNames reports whether the store keeps bucket and key names hidden.
+func (s *Store) HidesNames() bool {
+	return s.hidden
+}
+
+// Locked reports whether the store hides the names
+// and it has not been unlocked with the key yet.
+func (s *Store) Locked() bool {
+	return s.hidden && s.nameKey == nil
+}
+
+// Unlock sets the key of the store, verifying it
+// against the store verifier if the store hides the names.
+func (s *Store) Unlock(key []byte) error {
+	if !s.hidden {
+		s.key = key
+		return nil
+	}
+
+	nameKey := deriveNameKey(key)
+	var ok bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		names := tx.Bucket([]byte(namesBucket))
+		ok = names != nil && hmac.Equal(names.Get(verifierKey), nameID(nameKey, 'v'))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrWrongKey
+	}
+
+	s.key, s.nameKey = key, nameKey
+	return nil
+}
+
+// CheckKey verifies that the store key opens the store: a store hiding
+// the names has already checked it against its verifier when unlocked,
+// the others decrypt their first encrypted value. It returns ErrWrongKey
+// if the key does not match.
+func (s *Store) CheckKey() error {
+	if s.key == nil || s.Locked() {
+		return ErrKeyRequired
+	}
+	if s.hidden {
+		return nil
+	}
+
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if found || isReserved(name) {
+				return nil
+			}
+			return b.ForEach(func(_, v []byte) error {
+				if found || v == nil {
+					return nil
+				}
+				head, err := peekValue(tx, v, 1)
+				if err != nil || !IsEncrypted(head) {
+					return err
+				}
+
+				found = true
+				dat, err := loadValue(tx, v)
+				if err != nil {
+					return err
+				}
+				r, err := aes.NewReader(bytes.NewReader(dat[1:]), s.key)
+				if err == nil {
+					_, err = io.Copy(io.Discard, r)
+				}
+				if err != nil {
+					return ErrWrongKey
+				}
+				return nil
+			})
+		})
+	})
+	if err == nil && !found {
+		err = ErrNothingToVerify
+	}
+	return err
+}
+
+// HideNames converts the store so that its bucket and key names are
+// hidden; the store must have the key. All the names are converted
+// in a single transaction.
+func (s *Store) HideNames() error {
+	if s.hidden {
+		return nil
+	}
+	if s.key == nil {
+		return ErrKeyRequired
+	}
+
+	nameKey := deriveNameKey(s.key)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		names, err := tx.CreateBucket([]byte(namesBucket))
+		if err != nil {
+			return err
+		}
+		if err := names.Put(verifierKey, nameID(nameKey, 'v')); err != nil {
+			return err
+		}
+		if err := clearChanges(tx); err != nil {
+			return err
+		}
+		err = remapExpiries(tx, func(bid, kid []byte) ([]byte, []byte, error) {
+			bucket := string(bid)
+			return nameID(nameKey, 'b', bucket), nameID(nameKey, 'k', bucket, string(kid)), nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// buckets cannot be created while iterating over them
+		var buckets []string
+		err = tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if !isReserved(name) {
+				buckets = append(buckets, string(name))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, bucket := range buckets {
+			src := tx.Bucket([]byte(bucket))
+			id := nameID(nameKey, 'b', bucket)
+			dst, err := tx.CreateBucket(id)
+			if err != nil {
+				return err
+			}
+			if err := s.putName(names, id, bucket); err != nil {
+				return err
+			}
+
+			err = src.ForEach(func(k, v []byte) error {
+				if v == nil {
+					return fmt.Errorf("kvs: cannot hide the nested bucket '%s' of '%s'", k, bucket)
+				}
+
+				id := nameID(nameKey, 'k', bucket, string(k))
+				if err := s.putName(names, id, string(k)); err != nil {
+					return err
+				}
+				return dst.Put(id, v)
+			})
+			if err != nil {
+				return err
+			}
+
+			if err := tx.DeleteBucket([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.hidden, s.nameKey = true, nameKey
+	return nil
+}
+
+func deriveNameKey(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("kvs names"))
+	return mac.Sum(nil)
+}
+
+// nameID returns the identifier of a name of the specified kind.
+func nameID(nameKey []byte, kind byte, parts ...string) []byte {
+	mac := hmac.New(sha256.New, nameKey)
+	mac.Write([]byte{kind})
+	for i, el := range parts {
+		if i > 0 {
+			mac.Write([]byte{0x00})
+		}
+		mac.Write([]byte(el))
+	}
+
+	sum := mac.Sum(nil)
+	res := make([]byte, hex.EncodedLen(len(sum)))
+	hex.Encode(res, sum)
+	return res
+}
+
+// bucketID returns the name under which the bucket is stored.
+func (s *Store) bucketID(bucket string) ([]byte, error) {
+	if !s.hidden {
+		return []byte(bucket), nil
+	}
+	if s.nameKey == nil {
+		return nil, ErrKeyRequired
+	}
+	return nameID(s.nameKey, 'b', bucket), nil
+}
+
+// keyID returns the name under which the key of the bucket is stored,
+// normalized according to the key policy.
+func (s *Store) keyID(bucket, k string) ([]byte, error) {
+	k = s.keyPolicy.Normalize(k)
+	if !s.hidden {
+		return []byte(k), nil
+	}
+	if s.nameKey == nil {
+		return nil, ErrKeyRequired
+	}
+	return nameID(s.nameKey, 'k', bucket, k), nil
+}
+
+// bucket returns the bucket with the specified name, nil if it does not exist.
+func (s *Store) bucket(tx *bolt.Tx, name string) (*bolt.Bucket, error) {
+	id, err := s.bucketID(name)
+	if err != nil {
+		return nil, err
+	}
+	return tx.Bucket(id), nil
+}
+
+// createBucket returns the bucket with the specified name,
+// creating it if it does not exist.
+func (s *Store) createBucket(tx *bolt.Tx, name string) (*bolt.Bucket, error) {
+	id, err := s.bucketID(name)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := tx.CreateBucketIfNotExists(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, s.register(tx, id, name)
+}
+
+// get returns the value stored for the key of the bucket,
+// valid only during the transaction.
+func (s *Store) get(tx *bolt.Tx, bucket, k string) ([]byte, error) {
+	b, err := s.bucket(tx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, ErrBucketNotFound
+	}
+
+	id, err := s.keyID(bucket, k)
+	if err != nil {
+		return nil, err
+	}
+
+	v := b.Get(id)
+	if v == nil {
+		return nil, ErrKeyNotFound
+	}
+	if bid, _ := s.bucketID(bucket); expired(tx, bid, id) {
+		return nil, ErrKeyNotFound
+	}
+	return v, nil
+}
+
+// putKey stores the value for the key of the bucket b.
+func (s *Store) putKey(tx *bolt.Tx, b *bolt.Bucket, bucket, k string, v []byte) error {
+	id, err := s.keyID(bucket, k)
+	if err != nil {
+		return err
+	}
+	if err := s.register(tx, id, s.keyPolicy.Normalize(k)); err != nil {
+		return err
+	}
+	// an expired key is written anew
+	if bid, _ := s.bucketID(bucket); expired(tx, bid, id) {
+		if err := deleteExpiry(tx, bid, id); err != nil {
+			return err
+		}
+	}
+	if err := putValue(tx, b, id, v); err != nil {
+		return err
+	}
+	return s.logChange(tx, OpSet, bucket, s.keyPolicy.Normalize(k))
+}
+
+// deleteKey deletes the value stored for the key of the bucket b.
+func (s *Store) deleteKey(tx *bolt.Tx, b *bolt.Bucket, bucket, k string) error {
+	id, err := s.keyID(bucket, k)
+	if err != nil {
+		return err
+	}
+	if b.Get(id) != nil {
+		if err := s.logChange(tx, OpDelete, bucket, s.keyPolicy.Normalize(k)); err != nil {
+			return err
+		}
+	}
+	if err := deleteValue(tx, b, id); err != nil {
+		return err
+	}
+	if bid, err := s.bucketID(bucket); err == nil {
+		if err := deleteExpiry(tx, bid, id); err != nil {
+			return err
+		}
+	}
+	return s.unregister(tx, id)
+}
+
+// realName returns the name stored under the specified identifier.
+func (s *Store) realName(tx *bolt.Tx, id []byte) (string, error) {
+	if !s.hidden {
+		return string(id), nil
+	}
+	if s.nameKey == nil {
+		return "", ErrKeyRequired
+	}
+
+	var enc []byte
+	if names := tx.Bucket([]byte(namesBucket)); names != nil {
+		enc = names.Get(id)
+	}
+	if enc == nil {
+		return "", fmt.Errorf("kvs: unknown name %s", id)
+	}
+
+	res, err := s.unseal(enc)
+	return string(res), err
+}
+
+// register keeps the encrypted name of the identifier, if the store hides the names.
+func (s *Store) register(tx *bolt.Tx, id []byte, name string) error {
+	if !s.hidden {
+		return nil
+	}
+
+	names := tx.Bucket([]byte(namesBucket))
+	if names == nil {
+		return fmt.Errorf("kvs: missing names bucket")
+	}
+	if names.Get(id) != nil {
+		return nil
+	}
+	return s.putName(names, id, name)
+}
+
+// unregister forgets the name of the identifier, if the store hides the names.
+func (s *Store) unregister(tx *bolt.Tx, id []byte) error {
+	if !s.hidden {
+		return nil
+	}
+
+	names := tx.Bucket([]byte(namesBucket))
+	if names == nil {
+		return nil
+	}
+	return names.Delete(id)
+}
+
+func (s *Store) putName(names *bolt.Bucket, id []byte, name string) error {
+	enc, err := s.seal([]byte(name))
+	if err != nil {
+		return err
+	}
+	return names.Put(id, enc)
+}
+
+// seal encrypts the names with the key of the store.
+func (s *Store) seal(v []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := aes.NewWriter(&buf, s.key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(v); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *Store) unseal(enc []byte) ([]byte, error) {
+	r, err := aes.NewReader(bytes.NewReader(enc), s.key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestHideNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.kvs")
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	db, err := New(Options{BucketName: "google", Path: path, Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("password", []byte("s3cr3t")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.HideNames(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SetRaw("large", bytes.NewReader(make([]byte, ChunkSize+1)), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SAdd("tags", []byte("mail")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"google", "password", "large", "tags"} {
+		if bytes.Contains(dat, []byte(name)) {
+			t.Fatalf("the store file contains the name '%s'", name)
+		}
+	}
+
+	db, err = New(Options{BucketName: "google", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !db.Locked() {
+		t.Fatal("expected a locked store")
+	}
+	if n := db.BucketCount(); n != 1 {
+		t.Fatalf("expected 1 bucket, got %d", n)
+	}
+	if _, err := db.Get("password"); err != ErrKeyRequired {
+		t.Fatalf("expected ErrKeyRequired, got %v", err)
+	}
+	if err := db.Unlock(bytes.Repeat([]byte{0x24}, 32)); err != ErrWrongKey {
+		t.Fatalf("expected ErrWrongKey, got %v", err)
+	}
+	if err := db.Unlock(key); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	got, err := db.Get("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "s3cr3t" {
+		t.Fatalf("expected 's3cr3t', got '%s'", got)
+	}
+
+	keys := db.Keys()
+	sort.Strings(keys)
+	if want := []string{"large", "password", "tags"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("expected %v, got %v", want, keys)
+	}
+
+	if err := db.RenameBucket("google", "work"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Move("work", "large", "work", "big", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.Buckets(); !reflect.DeepEqual(got, []string{"work"}) {
+		t.Fatalf("unexpected buckets: %v", got)
+	}
+
+	var walked []string
+	err = db.Walk("", func(bucket, key string, value []byte) error {
+		walked = append(walked, bucket+"/"+key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(walked)
+	if want := []string{"work/big", "work/password", "work/tags"}; !reflect.DeepEqual(walked, want) {
+		t.Fatalf("expected %v, got %v", want, walked)
+	}
+
+	if err := db.DeleteBucket("work"); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.Buckets(); len(got) != 0 {
+		t.Fatalf("unexpected buckets: %v", got)
+	}
+}
+
+func TestCheckKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.kvs")
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	db, err := New(Options{BucketName: "google", Path: path, Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Set("plain", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckKey(); err != ErrNothingToVerify {
+		t.Fatalf("expected ErrNothingToVerify, got %v", err)
+	}
+
+	enc := &Store{db: db.db, bucketName: "google", key: key, encrypt: true}
+	if err := enc.Set("password", []byte("s3cr3t")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	wrong := &Store{db: db.db, key: bytes.Repeat([]byte{0x24}, 32)}
+	if err := wrong.CheckKey(); err != ErrWrongKey {
+		t.Fatalf("expected ErrWrongKey, got %v", err)
+	}
+	if err := (&Store{db: db.db}).CheckKey(); err != ErrKeyRequired {
+		t.Fatalf("expected ErrKeyRequired, got %v", err)
+	}
+}
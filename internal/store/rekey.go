@@ -0,0 +1,196 @@
+package store
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/lucasepe/kvs/internal/aes"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Rekey encrypts again, with newKey, all the values encrypted with the
+// store key and, if the store hides them, all the names; everything
+// is done in a single transaction, so a value that cannot be decrypted
+// with the store key leaves the store unchanged.
+// The values encrypted with the store key by older versions (base64)
+// are converted to the current format as well.
+// It returns the number of values encrypted again.
+func (s *Store) Rekey(newKey []byte) (int, error) {
+	if s.key == nil {
+		return 0, ErrKeyRequired
+	}
+
+	// ns encrypts with the new key
+	ns := &Store{db: s.db, key: newKey, hidden: s.hidden}
+	if s.hidden {
+		ns.nameKey = deriveNameKey(newKey)
+	}
+
+	var count int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		// the expired values would be left encrypted with the old key
+		if _, err := s.purgeExpiredTx(tx); err != nil {
+			return err
+		}
+
+		var err error
+		count, err = s.rewriteTx(tx, "", func(_, _ string, v []byte) ([]byte, bool, error) {
+			if dat, ok := legacyValue(v, s.key); ok {
+				res, err := ns.encodeAs(dat, Encoding{Encrypted: true}, KindBlob)
+				return res, err == nil, err
+			}
+			if !IsEncrypted(v) {
+				return nil, false, nil
+			}
+			res, err := reencrypt(v, s.key, newKey)
+			return res, err == nil, err
+		})
+		if err != nil {
+			return err
+		}
+
+		if s.hidden {
+			return s.rekeyNames(tx, ns)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.key, s.nameKey = ns.key, ns.nameKey
+	return count, nil
+}
+
+// rekeyNames moves every bucket and key under the identifiers
+// of the new store ns and rebuilds the names bucket.
+func (s *Store) rekeyNames(tx *bolt.Tx, ns *Store) error {
+	type entry struct {
+		id   []byte
+		name string
+	}
+	type bucketEntry struct {
+		entry
+		keys []entry
+	}
+
+	var buckets []bucketEntry
+	err := tx.ForEach(func(id []byte, b *bolt.Bucket) error {
+		if isReserved(id) {
+			return nil
+		}
+
+		name, err := s.realName(tx, id)
+		if err != nil {
+			return err
+		}
+		el := bucketEntry{entry: entry{append([]byte{}, id...), name}}
+
+		err = b.ForEach(func(k, _ []byte) error {
+			name, err := s.realName(tx, k)
+			if err != nil {
+				return err
+			}
+			el.keys = append(el.keys, entry{append([]byte{}, k...), name})
+			return nil
+		})
+		buckets = append(buckets, el)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// the names are still readable only before the names bucket is replaced
+	err = remapExpiries(tx, func(bid, kid []byte) ([]byte, []byte, error) {
+		bucket, err := s.realName(tx, bid)
+		if err != nil {
+			return nil, nil, err
+		}
+		name, err := s.realName(tx, kid)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nameID(ns.nameKey, 'b', bucket), nameID(ns.nameKey, 'k', bucket, name), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.DeleteBucket([]byte(namesBucket)); err != nil {
+		return err
+	}
+	names, err := tx.CreateBucket([]byte(namesBucket))
+	if err != nil {
+		return err
+	}
+	if err := names.Put(verifierKey, nameID(ns.nameKey, 'v')); err != nil {
+		return err
+	}
+	if err := clearChanges(tx); err != nil {
+		return err
+	}
+
+	for _, el := range buckets {
+		src := tx.Bucket(el.id)
+		id := nameID(ns.nameKey, 'b', el.name)
+		dst, err := tx.CreateBucket(id)
+		if err != nil {
+			return err
+		}
+		if err := ns.putName(names, id, el.name); err != nil {
+			return err
+		}
+
+		for _, k := range el.keys {
+			id := nameID(ns.nameKey, 'k', el.name, k.name)
+			if err := dst.Put(id, src.Get(k.id)); err != nil {
+				return err
+			}
+			if err := ns.putName(names, id, k.name); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.DeleteBucket(el.id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reencrypt decrypts the raw value with key and encrypts it with newKey,
+// keeping the compression, if any, as it is; the flags of the values
+// encrypted by older versions are recorded.
+func reencrypt(v, key, newKey []byte) ([]byte, error) {
+	var r io.Reader
+	r, err := aes.NewReader(bytes.NewReader(v[1:]), key)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := v[0]
+	if flags&flagLegacy != 0 {
+		dat, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		flags = flagEncrypted | legacyFlags(dat)
+		r = bytes.NewReader(dat)
+	}
+
+	buf := bytes.NewBuffer([]byte{flags})
+	w, err := aes.NewWriter(buf, newKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lucasepe/kvs/internal/aes"
+)
+
+func TestRekey(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{0x42}, 32)
+	newKey := bytes.Repeat([]byte{0x24}, 32)
+
+	for _, hide := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "test.kvs")
+
+		db, err := New(Options{BucketName: "google", Path: path, Key: oldKey, Encrypt: true, Compression: Gzip})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Set("password", []byte("s3cr3t")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.SAdd("tags", []byte("mail")); err != nil {
+			t.Fatal(err)
+		}
+		if hide {
+			if err := db.HideNames(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		n, err := db.Rekey(newKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 {
+			t.Fatalf("expected 2 values encrypted again, got %d", n)
+		}
+		db.Close()
+
+		db, err = New(Options{BucketName: "google", Path: path, Key: oldKey})
+		if hide {
+			if err != ErrWrongKey {
+				t.Fatalf("expected ErrWrongKey, got %v", err)
+			}
+		} else {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Get("password"); err == nil {
+				t.Fatal("expected an error decrypting with the old key")
+			}
+			db.Close()
+		}
+
+		db, err = New(Options{BucketName: "google", Path: path, Key: newKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.Get("password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "s3cr3t" {
+			t.Fatalf("expected 's3cr3t', got '%s'", got)
+		}
+		if ok, err := db.SIsMember("tags", []byte("mail")); err != nil || !ok {
+			t.Fatalf("expected 'mail' in tags, got %v, %v", ok, err)
+		}
+		db.Close()
+	}
+}
+
+func TestRekeyLegacy(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{0x42}, 32)
+	newKey := bytes.Repeat([]byte{0x24}, 32)
+	path := filepath.Join(t.TempDir(), "test.kvs")
+
+	db, err := New(Options{BucketName: "google", Path: path, Key: oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the base64 ciphertext written by the older versions
+	ct, err := aes.GcmEncrypt([]byte("s3cr3t"), oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("password", []byte(base64.RawStdEncoding.EncodeToString(ct))); err != nil {
+		t.Fatal(err)
+	}
+	// a plain value that only looks like base64
+	plain := strings.Repeat("abcd", 16)
+	if err := db.Set("token", []byte(plain)); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := db.Rekey(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 value converted, got %d", n)
+	}
+	db.Close()
+
+	db, err = New(Options{BucketName: "google", Path: path, Key: newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if got, err := db.Get("password"); err != nil || string(got) != "s3cr3t" {
+		t.Fatalf("expected 's3cr3t', got '%s', %v", got, err)
+	}
+	if got, err := db.Get("token"); err != nil || string(got) != plain {
+		t.Fatalf("expected the plain value, got '%s', %v", got, err)
+	}
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"bytes"
+	"io"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BucketStats describes the values stored in a bucket.
+type BucketStats struct {
+	// Name of the bucket.
+	Name string `json:"name"`
+	// Keys is the number of keys.
+	Keys int `json:"keys"`
+	// Size of the values as they are stored.
+	Size int64 `json:"size"`
+	// RawSize of the values once decrypted and decompressed;
+	// encrypted values count as their stored size if the store has no key.
+	RawSize int64 `json:"rawSize"`
+	// Compressed is the number of compressed values.
+	Compressed int `json:"compressed"`
+	// Encrypted is the number of encrypted values.
+	Encrypted int `json:"encrypted"`
+	// Chunked is the number of values split in chunks.
+	Chunked int `json:"chunked"`
+}
+
+// Stats returns the statistics of the specified bucket,
+// or of every bucket if the name is empty.
+func (s *Store) Stats(bucket string) ([]BucketStats, error) {
+	var res []BucketStats
+	err := s.db.View(func(tx *bolt.Tx) error {
+		index := map[string]int{}
+
+		return s.walkTx(tx, bucket, func(bucket, key string, value []byte) error {
+			i, ok := index[bucket]
+			if !ok {
+				i = len(res)
+				index[bucket] = i
+				res = append(res, BucketStats{Name: bucket})
+			}
+			st := &res[i]
+
+			st.Keys++
+			st.Size += int64(len(value))
+
+			v, err := s.get(tx, bucket, key)
+			if err != nil {
+				return err
+			}
+			if isManifest(v) {
+				st.Chunked++
+			}
+
+			if IsEncrypted(value) {
+				st.Encrypted++
+			}
+
+			raw, compressed, err := s.rawSize(value)
+			if err != nil {
+				return err
+			}
+			st.RawSize += raw
+			if compressed {
+				st.Compressed++
+			}
+
+			return nil
+		})
+	})
+
+	return res, err
+}
+
+// rawSize returns the size of the value once decrypted and decompressed.
+func (s *Store) rawSize(v []byte) (int64, bool, error) {
+	r, enc, err := newDecoder(bytes.NewReader(v), s.key)
+	if err != nil {
+		return 0, false, err
+	}
+	if enc.Encrypted && s.key == nil {
+		return int64(len(v)), IsCompressed(v), nil
+	}
+
+	n, err := io.Copy(io.Discard, r)
+	return n, enc.Compression != NoCompression, err
+}
@@ -1,8 +1,11 @@
 package store
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"os"
+	"sort"
 	"time"
 
 	bolt "go.etcd.io/bbolt"
@@ -16,6 +19,25 @@ type Options struct {
 	Path string
 
 	Timeout time.Duration
+
+	// Key is the encryption key used to decrypt the values
+	// and, if Encrypt is true, to encrypt them.
+	Key []byte
+	// Encrypt the values written by Set and SetStream.
+	Encrypt bool
+	// Compression applied to the values written by Set
+	// and SetStream, before the encryption.
+	Compression Compression
+	// CompactThreshold is the fraction of free space in the file
+	// (0 < threshold < 1) above which Close compacts the store;
+	// zero disables the auto-compaction.
+	CompactThreshold float64
+	// Outdated allows to open a store written by an older version,
+	// to back it up or to migrate it (see Migrate).
+	Outdated bool
+	// ReadOnly opens the store in read-only mode: the lock is shared
+	// with the other readers, while writers wait for it.
+	ReadOnly bool
 }
 
 // New creates a new bbolt store.
@@ -30,66 +52,199 @@ func New(options Options) (*Store, error) {
 		options.Timeout = 50 * time.Millisecond
 	}
 
+	// bbolt would create the missing file, even if read-only
+	if options.ReadOnly {
+		if _, err := os.Stat(options.Path); err != nil {
+			return nil, err
+		}
+	}
+
 	// Open DB
-	db, err := bolt.Open(options.Path, 0600, &bolt.Options{
-		Timeout: options.Timeout,
-	})
+	result.boltOptions = &bolt.Options{
+		Timeout:  options.Timeout,
+		ReadOnly: options.ReadOnly,
+	}
+	db, err := openBolt(options.Path, result.boltOptions)
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, ErrLocked
+	}
 	if err != nil {
-		return result, err
+		return nil, err
 	}
 
 	result.db = db
 	result.bucketName = options.BucketName
+	result.key = options.Key
+	result.encrypt = options.Encrypt
+	result.compression = options.Compression
+	result.compactThreshold = options.CompactThreshold
+
+	err = result.initMeta()
+	if err == nil {
+		err = db.View(func(tx *bolt.Tx) error {
+			result.hidden = tx.Bucket([]byte(namesBucket)) != nil
+			return result.loadMeta(tx)
+		})
+	}
+	if err == nil {
+		err = result.checkVersion(options.Outdated)
+	}
+	if err == nil && result.hidden && options.Key != nil {
+		err = result.Unlock(options.Key)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
 	return result, nil
 }
 
+// openBolt opens the bbolt file at path and, once it holds the lock, checks
+// that the file is still the one at path: Compact renames a new file over
+// the store while the other processes wait for the lock of the old one.
+func openBolt(path string, options *bolt.Options) (*bolt.DB, error) {
+	for {
+		var f *os.File
+		opts := *options
+		opts.OpenFile = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+			var err error
+			f, err = os.OpenFile(name, flag, perm)
+			return f, err
+		}
+
+		db, err := bolt.Open(path, 0600, &opts)
+		if err != nil {
+			return nil, err
+		}
+
+		cur, err := os.Stat(path)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		if fi, err := f.Stat(); err == nil && os.SameFile(fi, cur) {
+			return db, nil
+		}
+		db.Close()
+	}
+}
+
 var (
 	// ErrBucketNotFound is returned when the bucket name supplied does not exists
 	ErrBucketNotFound = errors.New("kvs: bucket not found")
+	// ErrConflict is returned when a value has been modified concurrently
+	ErrConflict = errors.New("kvs: value modified by another process")
+	// ErrLocked is returned when the store is still locked
+	// by another process once the timeout expires
+	ErrLocked = errors.New("kvs: store is locked by another process")
 )
 
 type Store struct {
-	db         *bolt.DB
-	bucketName string
+	db          *bolt.DB
+	bucketName  string
+	key         []byte
+	encrypt     bool
+	compression Compression
+
+	// hidden is true if bucket and key names are hidden,
+	// nameKey is the key of their identifiers (see names.go).
+	hidden  bool
+	nameKey []byte
+
+	// version is the format version of the store and
+	// keyPolicy normalizes the keys (see meta.go).
+	version   int
+	keyPolicy KeyPolicy
+
+	boltOptions      *bolt.Options
+	compactThreshold float64
 }
 
-// Set stores the given value for the given key.
+// Set stores the given value for the given key, compressed
+// and encrypted according to the store options.
 // The key must not be "" and the value must not be nil.
 func (s *Store) Set(k string, v []byte) error {
+	dat, err := s.Encode(v)
+	if err != nil {
+		return err
+	}
+
 	return s.db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte(s.bucketName))
+		b, err := s.createBucket(tx, s.bucketName)
 		if err != nil {
 			return err
 		}
-		return b.Put([]byte(k), v)
+		return s.putKey(tx, b, s.bucketName, k, dat)
 	})
 }
 
-// Get retrieves the stored value for the given key.
+// Get retrieves the stored value for the given key, decrypted
+// (if the store has the key) and decompressed.
 // The key must not be "" and the pointer must not be nil.
 func (s *Store) Get(k string) (v []byte, err error) {
 	var data []byte
 	err = s.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(s.bucketName))
+		b, err := s.bucket(tx, s.bucketName)
+		if err != nil {
+			return err
+		}
 		if b == nil {
 			return ErrBucketNotFound
 		}
 
-		txData := b.Get([]byte(k))
-		// txData is only valid during the transaction.
-		// Its value must be copied to make it valid outside of the tx.
-		// TODO: Benchmark if it's faster to copy + close tx,
-		// or to keep the tx open until unmarshalling is done.
-		if txData != nil {
-			// `data = append([]byte{}, txData...)` would also work, but the following is more explicit
-			data = make([]byte, len(txData))
-			copy(data, txData)
+		id, err := s.keyID(s.bucketName, k)
+		if err != nil {
+			return err
 		}
-		return nil
+
+		// expired keys are missing as well
+		if bid, _ := s.bucketID(s.bucketName); expired(tx, bid, id) {
+			return nil
+		}
+
+		// getValue returns a copy of the value, since the stored data
+		// is only valid during the transaction.
+		data, err = getValue(tx, b, id)
+		return err
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	return data, err
+	return s.Decode(data)
+}
+
+// CompareAndSet stores the given raw value for the given key only if the
+// current raw value is still equal to old (nil meaning the key does not exist).
+// Use Encode to obtain the raw value to store, split in chunks as SetRaw does.
+// It returns ErrConflict if the value has been changed in the meantime.
+func (s *Store) CompareAndSet(k string, old, v []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := s.createBucket(tx, s.bucketName)
+		if err != nil {
+			return err
+		}
+
+		id, err := s.keyID(s.bucketName, k)
+		if err != nil {
+			return err
+		}
+
+		cur, err := getValue(tx, b, id)
+		if err != nil {
+			return err
+		}
+		if bid, _ := s.bucketID(s.bucketName); expired(tx, bid, id) {
+			cur = nil
+		}
+		if (cur == nil) != (old == nil) || !bytes.Equal(cur, old) {
+			return ErrConflict
+		}
+
+		_, err = s.storeValue(tx, b, s.bucketName, k, bytes.NewReader(v), 0)
+		return err
+	})
 }
 
 // Delete deletes the stored value for the given key.
@@ -97,11 +252,81 @@ func (s *Store) Get(k string) (v []byte, err error) {
 // The key must not be "".
 func (s *Store) Delete(k string) error {
 	return s.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(s.bucketName))
+		b, err := s.bucket(tx, s.bucketName)
+		if err != nil {
+			return err
+		}
 		if b == nil {
 			return ErrBucketNotFound
 		}
-		return b.Delete([]byte(k))
+		return s.deleteKey(tx, b, s.bucketName, k)
+	})
+}
+
+// SetAll stores the values, compressed and encrypted as by Set, in a single
+// transaction: nil values delete their keys, as do the keys missing from
+// values if replace is true.
+func (s *Store) SetAll(values map[string][]byte, replace bool) error {
+	keys := make([]string, 0, len(values))
+	dat := make(map[string][]byte, len(values))
+	for k, v := range values {
+		keys = append(keys, k)
+		if v == nil {
+			continue
+		}
+		enc, err := s.Encode(v)
+		if err != nil {
+			return err
+		}
+		dat[k] = enc
+	}
+	sort.Strings(keys)
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := s.createBucket(tx, s.bucketName)
+		if err != nil {
+			return err
+		}
+
+		if replace {
+			wanted := make(map[string]bool, len(keys))
+			for _, k := range keys {
+				wanted[s.keyPolicy.Normalize(k)] = true
+			}
+
+			// the bucket cannot be modified while iterating over it
+			var missing []string
+			err := b.ForEach(func(id, v []byte) error {
+				if v == nil {
+					return nil
+				}
+				name, err := s.realName(tx, id)
+				if err == nil && !wanted[name] {
+					missing = append(missing, name)
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			for _, k := range missing {
+				if err := s.deleteKey(tx, b, s.bucketName, k); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, k := range keys {
+			if v, ok := dat[k]; ok {
+				err = s.putKey(tx, b, s.bucketName, k, v)
+			} else {
+				err = s.deleteKey(tx, b, s.bucketName, k)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 }
 
@@ -109,7 +334,44 @@ func (s *Store) Delete(k string) error {
 // Returns an error if the bucket cannot be found or if the key represents a non-bucket value.
 func (s *Store) DeleteBucket(bucket string) error {
 	return s.db.Update(func(tx *bolt.Tx) error {
-		return tx.DeleteBucket([]byte(bucket))
+		id, err := s.bucketID(bucket)
+		if err != nil {
+			return err
+		}
+
+		b := tx.Bucket(id)
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+		if err := deleteBucketChunks(tx, b); err != nil {
+			return err
+		}
+		if err := deleteBucketExpiries(tx, id); err != nil {
+			return err
+		}
+		err = b.ForEach(func(k, _ []byte) error {
+			name, err := s.realName(tx, k)
+			if err != nil {
+				return err
+			}
+			return s.logChange(tx, OpDelete, bucket, name)
+		})
+		if err != nil {
+			return err
+		}
+		if s.hidden {
+			err := b.ForEach(func(k, _ []byte) error {
+				return s.unregister(tx, k)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := tx.DeleteBucket(id); err != nil {
+			return err
+		}
+		return s.unregister(tx, id)
 	})
 }
 
@@ -118,15 +380,25 @@ func (s *Store) Keys() []string {
 	var res []string
 	s.db.View(func(tx *bolt.Tx) error {
 		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte(s.bucketName))
+		b, err := s.bucket(tx, s.bucketName)
+		if err != nil {
+			return err
+		}
 		if b == nil {
 			return ErrBucketNotFound
 		}
 
+		bid, _ := s.bucketID(s.bucketName)
 		c := b.Cursor()
 		for k, _ := c.First(); k != nil; k, _ = c.Next() {
-			//binary.Write(w, binary.LittleEndian, k)
-			res = append(res, string(k[:]))
+			if expired(tx, bid, k) {
+				continue
+			}
+			name, err := s.realName(tx, k)
+			if err != nil {
+				return err
+			}
+			res = append(res, name)
 		}
 
 		return nil
@@ -140,11 +412,40 @@ func (s *Store) Buckets() []string {
 	var res []string
 	s.db.View(func(tx *bolt.Tx) error {
 		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
-			// Count only if the bucket has keys
-			if b.Stats().KeyN > 0 {
-				res = append(res, string(name[:]))
+			// Count only if the bucket has keys and it's not used internally
+			if isReserved(name) || b.Stats().KeyN == 0 {
+				return nil
+			}
+
+			real, err := s.realName(tx, name)
+			if err != nil {
+				return err
 			}
+			res = append(res, real)
+			return nil
+		})
+	})
+
+	return res
+}
+
+// InBucket returns a view of the store working on the specified bucket.
+// It shares the database of s, so it must not be closed.
+func (s *Store) InBucket(name string) *Store {
+	res := *s
+	res.bucketName = name
+	return &res
+}
 
+// BucketCount returns the number of buckets with keys,
+// without resolving their names: it works on locked stores too.
+func (s *Store) BucketCount() int {
+	var res int
+	s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if !isReserved(name) && b.Stats().KeyN > 0 {
+				res++
+			}
 			return nil
 		})
 	})
@@ -152,12 +453,23 @@ func (s *Store) Buckets() []string {
 	return res
 }
 
+// Path returns the path of the store file.
+func (s *Store) Path() string {
+	return s.db.Path()
+}
+
 // Close closes the store.
 // It must be called to make sure that all open transactions finish and to release all DB resources.
 func (s *Store) Close() error {
-	if s.db != nil {
-		return s.db.Close()
+	if s.db == nil {
+		return nil
+	}
+
+	if s.compactThreshold > 0 && s.shouldCompact() {
+		// the store is usable anyway, compaction is
+		// attempted again the next time
+		s.Compact()
 	}
 
-	return nil
+	return s.db.Close()
 }
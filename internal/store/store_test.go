@@ -0,0 +1,129 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompareAndSet(t *testing.T) {
+	db := newTestStore(t, "notes")
+
+	raw := map[string][]byte{}
+	for _, v := range []string{"v0", "v1", "v2"} {
+		dat, err := db.Encode([]byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw[v] = dat
+	}
+
+	if err := db.CompareAndSet("todo", nil, raw["v1"]); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CompareAndSet("todo", nil, raw["v2"]); err != ErrConflict {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if err := db.CompareAndSet("todo", raw["v0"], raw["v2"]); err != ErrConflict {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if err := db.CompareAndSet("todo", raw["v1"], raw["v2"]); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := db.Get("todo"); string(v) != "v2" {
+		t.Fatalf("want: %q, got: %q", "v2", v)
+	}
+
+	// large values are chunked, and their chunks released once replaced
+	big, err := db.Encode(bytes.Repeat([]byte("x"), 3*ChunkSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CompareAndSet("todo", raw["v2"], big); err != nil {
+		t.Fatal(err)
+	}
+	chunks := &Store{db: db.db, bucketName: chunksBucket}
+	if keys := chunks.Keys(); len(keys) == 0 {
+		t.Fatal("expected the large value to be chunked")
+	}
+	if err := db.CompareAndSet("todo", big, raw["v1"]); err != nil {
+		t.Fatal(err)
+	}
+	if keys := chunks.Keys(); len(keys) != 0 {
+		t.Fatalf("expected all chunks to be released, found %d", len(keys))
+	}
+	if err := db.CompareAndSet("todo", raw["v1"], []byte{0xff}); err == nil {
+		t.Fatal("expected a malformed value to be refused")
+	}
+}
+
+func TestSetAll(t *testing.T) {
+	db := newTestStore(t, "app")
+
+	if err := db.SetAll(map[string][]byte{"user": []byte("ada"), "port": []byte("5432")}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetAll(map[string][]byte{"port": nil, "host": []byte("db")}, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.Keys(); !reflect.DeepEqual(got, []string{"host", "user"}) {
+		t.Fatalf("unexpected keys: %v", got)
+	}
+
+	if err := db.SetAll(map[string][]byte{"user": []byte("bob")}, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.Keys(); !reflect.DeepEqual(got, []string{"user"}) {
+		t.Fatalf("unexpected keys: %v", got)
+	}
+	if v, _ := db.Get("user"); string(v) != "bob" {
+		t.Fatalf("want: %q, got: %q", "bob", v)
+	}
+}
+
+func TestReadOnlyAndLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.kvs")
+
+	if _, err := New(Options{Path: path, ReadOnly: true}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a missing file error, got %v", err)
+	}
+
+	w, err := New(Options{BucketName: "notes", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Set("todo", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(Options{Path: path, ReadOnly: true, Timeout: 10 * time.Millisecond}); err != ErrLocked {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	w.Close()
+
+	// readers share the lock
+	r1, err := New(Options{BucketName: "notes", Path: path, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r1.Close()
+	r2, err := New(Options{BucketName: "notes", Path: path, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+
+	if got, err := r2.Get("todo"); err != nil || string(got) != "v1" {
+		t.Fatalf("expected 'v1', got '%s', %v", got, err)
+	}
+	if err := r1.Set("todo", []byte("v2")); err == nil {
+		t.Fatal("expected an error writing a read-only store")
+	}
+	if _, err := New(Options{Path: path, Timeout: 10 * time.Millisecond}); err != ErrLocked {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+}
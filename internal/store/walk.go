@@ -0,0 +1,124 @@
+package store
+
+import (
+	"bytes"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// WalkFunc is the type of the function called by Walk for each key
+// with its raw value, as stored (see Decode). The value is only valid while the function is running
+// and must be copied to be used afterwards.
+type WalkFunc func(bucket, key string, value []byte) error
+
+// Walk calls fn for every key of the specified bucket, or of every
+// bucket if the name is empty, within a single read transaction.
+// Walking stops at the first error returned by fn.
+func (s *Store) Walk(bucket string, fn WalkFunc) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return s.walkTx(tx, bucket, fn)
+	})
+}
+
+func (s *Store) walkTx(tx *bolt.Tx, bucket string, fn WalkFunc) error {
+	if len(bucket) > 0 {
+		b, err := s.bucket(tx, bucket)
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			return ErrBucketNotFound
+		}
+		return s.walkBucket(tx, bucket, b, fn)
+	}
+
+	return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		if isReserved(name) {
+			return nil
+		}
+
+		real, err := s.realName(tx, name)
+		if err != nil {
+			return err
+		}
+		return s.walkBucket(tx, real, b, fn)
+	})
+}
+
+func (s *Store) walkBucket(tx *bolt.Tx, name string, b *bolt.Bucket, fn WalkFunc) error {
+	bid, err := s.bucketID(name)
+	if err != nil {
+		return err
+	}
+
+	return b.ForEach(func(k, v []byte) error {
+		// skip nested buckets and expired keys
+		if v == nil || expired(tx, bid, k) {
+			return nil
+		}
+
+		v, err := loadValue(tx, v)
+		if err != nil {
+			return err
+		}
+
+		key, err := s.realName(tx, k)
+		if err != nil {
+			return err
+		}
+		return fn(name, key, v)
+	})
+}
+
+// RewriteFunc is the type of the function called by Rewrite for each key.
+// It returns the new raw value and true if the value must be replaced.
+type RewriteFunc func(bucket, key string, value []byte) ([]byte, bool, error)
+
+// Rewrite calls fn for every key of the specified bucket, or of every
+// bucket if the name is empty, and stores the values it returns, all in a
+// single read-write transaction: if fn fails, nothing is changed.
+// It returns the number of replaced values.
+func (s *Store) Rewrite(bucket string, fn RewriteFunc) (int, error) {
+	var count int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		count, err = s.rewriteTx(tx, bucket, fn)
+		return err
+	})
+
+	return count, err
+}
+
+func (s *Store) rewriteTx(tx *bolt.Tx, bucket string, fn RewriteFunc) (int, error) {
+	type change struct {
+		bucket string
+		key    string
+		value  []byte
+	}
+
+	var changes []change
+	err := s.walkTx(tx, bucket, func(bucket, key string, value []byte) error {
+		res, ok, err := fn(bucket, key, value)
+		if err != nil || !ok {
+			return err
+		}
+		// the bucket cannot be modified while iterating over it
+		changes = append(changes, change{bucket, key, res})
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, c := range changes {
+		b, err := s.bucket(tx, c.bucket)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := s.storeValue(tx, b, c.bucket, c.key, bytes.NewReader(c.value), 0); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(changes), nil
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	db := newTestStore(t, "google")
+	db.Set("user", []byte("john"))
+	db.Copy("google", "user", "work", "email", false)
+
+	var got []string
+	err := db.Walk("", func(bucket, key string, value []byte) error {
+		v, err := db.Decode(value)
+		got = append(got, bucket+"/"+key+"="+string(v))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"google/user=john", "work/email=john"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want: %v, got: %v", want, got)
+	}
+
+	if err := db.Walk("missing", nil); err != ErrBucketNotFound {
+		t.Fatalf("expected ErrBucketNotFound, got %v", err)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	db := newTestStore(t, "google")
+	db.Set("user", []byte("john"))
+	db.Set("pass", []byte("secret"))
+
+	n, err := db.Rewrite("", func(bucket, key string, value []byte) ([]byte, bool, error) {
+		if key != "user" {
+			return nil, false, nil
+		}
+		v, err := db.Decode(value)
+		if err != nil {
+			return nil, false, err
+		}
+		v, err = db.Encode(append([]byte("mr. "), v...))
+		return v, true, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 rewritten value, got %d", n)
+	}
+
+	if v, _ := db.Get("user"); string(v) != "mr. john" {
+		t.Fatalf("unexpected value: %q", v)
+	}
+	if v, _ := db.Get("pass"); string(v) != "secret" {
+		t.Fatalf("unexpected value: %q", v)
+	}
+}
@@ -0,0 +1,230 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// changesBucket is the change log: the events, by revision,
+// as the operation followed by the bucket and key names joined
+// by joinPair (encrypted if the names are hidden).
+const changesBucket = reservedPrefix + "changes__"
+
+// maxChanges is the number of events kept in the change log.
+const maxChanges = 10000
+
+// watchInterval is how often Watch looks for new events.
+var watchInterval = 250 * time.Millisecond
+
+// Op is the operation of a change event.
+type Op byte
+
+const (
+	// OpSet is the write of a key.
+	OpSet Op = 's'
+	// OpDelete is the deletion of a key.
+	OpDelete Op = 'd'
+)
+
+func (op Op) String() string {
+	if op == OpDelete {
+		return "delete"
+	}
+	return "set"
+}
+
+func (op Op) MarshalText() ([]byte, error) {
+	return []byte(op.String()), nil
+}
+
+// Event is a change of a key.
+type Event struct {
+	// Revision increases with every change of the store.
+	Revision uint64 `json:"revision"`
+	Op       Op     `json:"op"`
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+}
+
+// Revision returns the revision of the last change of the store.
+func (s *Store) Revision() uint64 {
+	var res uint64
+	s.db.View(func(tx *bolt.Tx) error {
+		if ch := tx.Bucket([]byte(changesBucket)); ch != nil {
+			res = ch.Sequence()
+		}
+		return nil
+	})
+	return res
+}
+
+// Watch sends the changes of the keys of the bucket (of all the buckets
+// if empty) starting with prefix, made after the call by this or other
+// processes, until ctx is done; then the channel is closed.
+//
+// A read-only store is closed by Watch, so that the other processes can
+// write it, and can only be closed afterwards: the watcher opens the file
+// for every check instead.
+func (s *Store) Watch(ctx context.Context, bucket, prefix string) <-chan Event {
+	ch := make(chan Event, 64)
+	rev := s.Revision()
+
+	path := s.db.Path()
+	readOnly := s.db.IsReadOnly()
+	if readOnly {
+		s.db.Close()
+	}
+
+	go func() {
+		defer close(ch)
+
+		t := time.NewTicker(watchInterval)
+		defer t.Stop()
+
+		var last os.FileInfo
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+
+			db := s.db
+			var fi os.FileInfo
+			if readOnly {
+				// the file changes with every write
+				var err error
+				fi, err = os.Stat(path)
+				if err != nil || (last != nil && fi.Size() == last.Size() && fi.ModTime().Equal(last.ModTime())) {
+					continue
+				}
+
+				if db, err = bolt.Open(path, 0600, s.boltOptions); err != nil {
+					continue
+				}
+			}
+
+			events, err := s.changes(db, rev)
+			if readOnly {
+				db.Close()
+			}
+			if err != nil {
+				continue
+			}
+			// checked again until read
+			last = fi
+
+			for _, el := range events {
+				rev = el.Revision
+				if (len(bucket) > 0 && el.Bucket != bucket) || !strings.HasPrefix(el.Key, prefix) {
+					continue
+				}
+				select {
+				case ch <- el:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+// changes returns the events of the change log after the revision.
+func (s *Store) changes(db *bolt.DB, after uint64) ([]Event, error) {
+	var res []Event
+	err := db.View(func(tx *bolt.Tx) error {
+		log := tx.Bucket([]byte(changesBucket))
+		if log == nil {
+			return nil
+		}
+
+		c := log.Cursor()
+		for k, v := c.Seek(revisionKey(after + 1)); k != nil; k, v = c.Next() {
+			if len(v) == 0 {
+				continue
+			}
+
+			names := v[1:]
+			if s.hidden {
+				var err error
+				if names, err = s.unseal(names); err != nil {
+					return err
+				}
+			}
+			b, key := splitPair(names)
+
+			res = append(res, Event{
+				Revision: binary.BigEndian.Uint64(k),
+				Op:       Op(v[0]),
+				Bucket:   string(b),
+				Key:      string(key),
+			})
+		}
+		return nil
+	})
+
+	return res, err
+}
+
+// logChange appends the event to the change log,
+// dropping the oldest ones beyond maxChanges.
+func (s *Store) logChange(tx *bolt.Tx, op Op, bucket, key string) error {
+	log, err := tx.CreateBucketIfNotExists([]byte(changesBucket))
+	if err != nil {
+		return err
+	}
+
+	rev, err := log.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	names := joinPair([]byte(bucket), []byte(key))
+	if s.hidden {
+		if names, err = s.seal(names); err != nil {
+			return err
+		}
+	}
+	if err := log.Put(revisionKey(rev), append([]byte{byte(op)}, names...)); err != nil {
+		return err
+	}
+
+	if rev <= maxChanges {
+		return nil
+	}
+	c := log.Cursor()
+	for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= rev-maxChanges; k, _ = c.First() {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearChanges empties the change log, keeping the revision: its names
+// are in clear, or encrypted with the key, and would outlive a change.
+func clearChanges(tx *bolt.Tx) error {
+	log := tx.Bucket([]byte(changesBucket))
+	if log == nil {
+		return nil
+	}
+
+	c := log.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.First() {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func revisionKey(rev uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, rev)
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+	}
+	return Event{}
+}
+
+func TestWatch(t *testing.T) {
+	watchInterval = 10 * time.Millisecond
+	defer func() { watchInterval = 250 * time.Millisecond }()
+
+	db := newTestStore(t, "app")
+	if err := db.Set("old", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := db.Watch(ctx, "app", "db.")
+
+	other := db.InBucket("other")
+	if err := other.Set("db.host", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("ui.theme", []byte("dark")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("db.host", []byte("localhost")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("db.host"); err != nil {
+		t.Fatal(err)
+	}
+
+	ev := nextEvent(t, ch)
+	if ev.Op != OpSet || ev.Bucket != "app" || ev.Key != "db.host" {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	next := nextEvent(t, ch)
+	if next.Op != OpDelete || next.Key != "db.host" || next.Revision <= ev.Revision {
+		t.Fatalf("unexpected event: %+v", next)
+	}
+	if rev := db.Revision(); rev != next.Revision {
+		t.Fatalf("expected revision %d, got %d", next.Revision, rev)
+	}
+
+	cancel()
+	for range ch {
+	}
+}
+
+func TestWatchRenamed(t *testing.T) {
+	db := newTestStore(t, "app")
+	if err := db.Set("a", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Move("app", "a", "app", "b", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RenameBucket("app", "web"); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := db.changes(db.db, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, el := range events {
+		got = append(got, fmt.Sprintf("%c %s/%s", el.Op, el.Bucket, el.Key))
+	}
+	want := []string{"s app/a", "s app/b", "d app/a", "d app/b", "s web/b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestWatchReadOnly(t *testing.T) {
+	watchInterval = 10 * time.Millisecond
+	defer func() { watchInterval = 250 * time.Millisecond }()
+
+	path := filepath.Join(t.TempDir(), "test.kvs")
+	w, err := New(Options{BucketName: "app", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	r, err := New(Options{Path: path, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := r.Watch(ctx, "", "")
+
+	// the watcher does not keep the writers out
+	time.Sleep(50 * time.Millisecond)
+	w, err = New(Options{BucketName: "app", Path: path, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Set("k", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.DeleteBucket("app"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	if ev := nextEvent(t, ch); ev.Op != OpSet || ev.Bucket != "app" || ev.Key != "k" {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	if ev := nextEvent(t, ch); ev.Op != OpDelete || ev.Key != "k" {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+
+	cancel()
+	for range ch {
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("expected the watched store to be closed, got %v", err)
+	}
+}